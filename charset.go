@@ -0,0 +1,70 @@
+package mechanize
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// charsetEncoding returns the character encoding used to submit the
+// form: the first supported encoding listed in the accept-charset
+// attribute, or the encoding of the document that contained the form.
+// UTF-16 is never used for submissions, UTF-8 is used instead
+func (f *Form) charsetEncoding() (encoding.Encoding, string) {
+	enc, name := encoding.Encoding(nil), ""
+	if v, ok := getAttr(f.Node, "accept-charset"); ok {
+		labels := strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+		for _, label := range labels {
+			if enc, name = charset.Lookup(label); enc != nil {
+				break
+			}
+		}
+	}
+
+	if enc == nil && f.response != nil && f.response.encoding != nil {
+		enc, name = f.response.encoding, f.response.charset
+	}
+
+	if enc == nil || strings.HasPrefix(name, "utf-16") {
+		return encoding.Nop, "utf-8"
+	}
+	return enc, name
+}
+
+// transcodeEntries converts the names and values of the entries from
+// UTF-8 to enc. Characters that cannot be represented in enc are
+// replaced with HTML numeric character references, as browsers do
+func transcodeEntries(entries []FormEntry, enc encoding.Encoding) ([]FormEntry, error) {
+	if enc == encoding.Nop {
+		return entries, nil
+	}
+
+	encoder := encoding.HTMLEscapeUnsupported(enc.NewEncoder())
+	ret := make([]FormEntry, len(entries))
+	for i, e := range entries {
+		name, err := encoder.String(e.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := encoder.String(e.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Name = name
+		e.Value = value
+		ret[i] = e
+	}
+	return ret, nil
+}
+
+// encodedEntries returns the entries to be submitted, converted to the
+// character encoding of the form
+func (f *Form) encodedEntries(submitter FormField) ([]FormEntry, error) {
+	enc, _ := f.charsetEncoding()
+	return transcodeEntries(f.entries(submitter), enc)
+}
@@ -0,0 +1,247 @@
+package mechanize
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FillError is returned by Form.Fill and Form.FillStruct when some of
+// the values could not be set. Values that could be set are still set
+type FillError struct {
+	// Errors maps field names to the error that occurred
+	Errors map[string]error
+}
+
+func (e *FillError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = name + ": " + e.Errors[name].Error()
+	}
+	return "failed to fill form: " + strings.Join(msgs, ", ")
+}
+
+// Fill sets the values of multiple fields. The way a value is set
+// depends on its type:
+//
+//	string          Form.SetValue
+//	bool            checks or unchecks the checkboxes named name
+//	[]string        Form.SetValues, e.g. for multiple selects
+//	[]byte          uploads the bytes via a file field
+//	io.Reader       uploads the content of the reader via a file field
+//	*UploadFile     Form.SetFile
+//	[]*UploadFile   Form.SetFile
+//
+// Numbers are formatted and set as strings, and slices of numbers are
+// set with Form.SetValues. Pointers are dereferenced, and nil pointers
+// are skipped. Values of any other type are an error.
+// Errors are collected, and returned as a *FillError
+func (f *Form) Fill(values map[string]interface{}) error {
+	errs := map[string]error{}
+	for name, v := range values {
+		if err := f.fill(name, v); err != nil {
+			errs[name] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &FillError{Errors: errs}
+	}
+	return nil
+}
+
+func (f *Form) fill(name string, v interface{}) error {
+	switch x := v.(type) {
+	case string:
+		return f.SetValue(name, x)
+	case bool:
+		inputs := f.checkables(name)
+		if len(inputs) == 0 {
+			return errors.New("no checkbox named '" + name + "'")
+		}
+		for _, i := range inputs {
+			if err := f.checkWritable(i, false); err != nil {
+				return err
+			}
+			if x {
+				i.Check()
+			} else {
+				i.Uncheck()
+			}
+		}
+		return nil
+	case []string:
+		return f.SetValues(name, x...)
+	case []byte:
+		return f.SetFile(name, &UploadFile{Filename: "blob", Data: x})
+	case *UploadFile:
+		return f.SetFile(name, x)
+	case []*UploadFile:
+		return f.SetFile(name, x...)
+	case io.Reader:
+		// Files (e.g. *os.File) know their name. Otherwise, use the same
+		// name as browsers do for anonymous blobs
+		filename := "blob"
+		if named, ok := x.(interface{ Name() string }); ok {
+			filename = filepath.Base(named.Name())
+		}
+		return f.SetFile(name, &UploadFile{Filename: filename, Reader: x})
+	case nil:
+		return errors.New("nil value")
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return f.fill(name, rv.Elem().Interface())
+	case reflect.Bool:
+		// named boolean types are checkboxes too
+		return f.fill(name, rv.Bool())
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 && rv.Kind() == reflect.Slice {
+			return f.SetFile(name, &UploadFile{Filename: "blob", Data: rv.Bytes()})
+		}
+
+		values := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			ev := rv.Index(i)
+			if ev.Kind() == reflect.Interface {
+				ev = ev.Elem()
+			}
+			if ev.Kind() == reflect.Ptr {
+				if ev.IsNil() {
+					continue
+				}
+				ev = ev.Elem()
+			}
+			s, ok := scalarString(ev)
+			if !ok {
+				return errors.New("unsupported value of type " + rv.Type().String())
+			}
+			values = append(values, s)
+		}
+		return f.SetValues(name, values...)
+	}
+
+	if s, ok := scalarString(rv); ok {
+		return f.SetValue(name, s)
+	}
+	return errors.New("unsupported value of type " + rv.Type().String())
+}
+
+// scalarString formats strings, booleans and numbers (including named
+// types based on them) as strings
+func scalarString(rv reflect.Value) (string, bool) {
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), true
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), true
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 32), true
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// FillStruct is the same as Fill, but takes the values from the fields
+// of the struct (or pointer to struct) v. The field name to fill is
+// taken from the "form" struct tag, or the name of the struct field if
+// there is no tag. A tag of "-" skips the field, and the "omitempty"
+// option skips the field if it has a zero value:
+//
+//	type Login struct {
+//	  Username string `form:"username"`
+//	  Remember bool   `form:"remember,omitempty"`
+//	  Internal string `form:"-"`
+//	}
+func (f *Form) FillStruct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return errors.New("FillStruct requires a non-nil struct")
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return errors.New("FillStruct requires a struct, got " + rv.Kind().String())
+	}
+
+	values := map[string]interface{}{}
+	structValues(rv, values)
+	return f.Fill(values)
+}
+
+func structValues(rv reflect.Value, values map[string]interface{}) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		fv := rv.Field(i)
+
+		tag, hasTag := sf.Tag.Lookup("form")
+		if tag == "-" {
+			continue
+		}
+
+		// untagged embedded structs are flattened
+		if sf.Anonymous && !hasTag && fv.Kind() == reflect.Struct {
+			structValues(fv, values)
+			continue
+		}
+
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name := sf.Name
+		omitempty := false
+		if hasTag {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitempty = true
+				}
+			}
+		}
+
+		if omitempty && isZeroValue(fv) {
+			continue
+		}
+
+		if (fv.Kind() == reflect.Interface || fv.Kind() == reflect.Ptr) && fv.IsNil() {
+			continue
+		}
+		values[name] = fv.Interface()
+	}
+}
+
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+		return v.IsNil() || (v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface && v.Len() == 0)
+	}
+	return v.IsZero()
+}
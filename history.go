@@ -0,0 +1,391 @@
+package mechanize
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// HistoryEntry is a single request/response pair recorded in the
+// browsing history
+type HistoryEntry struct {
+	request  *http.Request
+	response *Response
+}
+
+// Request returns the *http.Request that was sent for this entry
+func (h *HistoryEntry) Request() *http.Request {
+	return h.request
+}
+
+// Response returns the *Response that was received for this entry
+func (h *HistoryEntry) Response() *Response {
+	return h.response
+}
+
+// HistoryStore is the storage for the browsing history. Entries are
+// indexed from 0 (oldest) to Len()-1 (newest).
+type HistoryStore interface {
+	// Len returns the number of entries in the store
+	Len() int
+	// Get returns the entry at index i
+	Get(i int) (*HistoryEntry, error)
+	// Set replaces the entry at index i
+	Set(i int, e *HistoryEntry) error
+	// Append adds a new entry at the end
+	Append(e *HistoryEntry) error
+	// Truncate discards all entries at index n and above
+	Truncate(n int) error
+	// Evict discards the n oldest entries
+	Evict(n int) error
+}
+
+var errHistoryIndexOutOfRange = errors.New("history index out of range")
+
+// MemoryHistoryStore keeps all entries in memory. This is the default
+type MemoryHistoryStore struct {
+	entries []*HistoryEntry
+}
+
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{}
+}
+
+func (s *MemoryHistoryStore) Len() int {
+	return len(s.entries)
+}
+
+func (s *MemoryHistoryStore) Get(i int) (*HistoryEntry, error) {
+	if i < 0 || i >= len(s.entries) {
+		return nil, errHistoryIndexOutOfRange
+	}
+	return s.entries[i], nil
+}
+
+func (s *MemoryHistoryStore) Set(i int, e *HistoryEntry) error {
+	if i < 0 || i >= len(s.entries) {
+		return errHistoryIndexOutOfRange
+	}
+	s.entries[i] = e
+	return nil
+}
+
+func (s *MemoryHistoryStore) Append(e *HistoryEntry) error {
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *MemoryHistoryStore) Truncate(n int) error {
+	if n < 0 || n > len(s.entries) {
+		return errHistoryIndexOutOfRange
+	}
+	for i := n; i < len(s.entries); i++ {
+		s.entries[i] = nil
+	}
+	s.entries = s.entries[:n]
+	return nil
+}
+
+func (s *MemoryHistoryStore) Evict(n int) error {
+	if n < 0 || n > len(s.entries) {
+		return errHistoryIndexOutOfRange
+	}
+	// copy, so that the evicted entries can be garbage collected
+	s.entries = append([]*HistoryEntry(nil), s.entries[n:]...)
+	return nil
+}
+
+// DiskHistoryStore serializes each entry to a file under a directory,
+// and only keeps the most recently accessed entry in memory. Entries
+// that are read back are re-parsed from the saved response body.
+// Multipart request bodies, which may contain large uploads, are not
+// saved, so those entries cannot be reloaded once they are read back.
+type DiskHistoryStore struct {
+	dir     string
+	tempDir bool
+	files   []string
+	mutex   sync.Mutex
+	cached  *HistoryEntry
+	cacheAt int
+}
+
+// diskHistoryEntry is the serialized form of a HistoryEntry
+type diskHistoryEntry struct {
+	Method         string
+	URL            string
+	Header         http.Header
+	Body           []byte
+	BodyOmitted    bool
+	ResponseURL    string
+	Status         string
+	StatusCode     int
+	Proto          string
+	ProtoMajor     int
+	ProtoMinor     int
+	ResponseHeader http.Header
+	RawBody        []byte
+}
+
+// NewDiskHistoryStore creates a new DiskHistoryStore that stores its
+// entries under dir. If dir is empty, a temporary directory is created,
+// and removed by Close. Several stores may share the same directory.
+func NewDiskHistoryStore(dir string) (*DiskHistoryStore, error) {
+	tempDir := dir == ""
+	if tempDir {
+		tmp, err := ioutil.TempDir("", "go-mechanize-history")
+		if err != nil {
+			return nil, err
+		}
+		dir = tmp
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &DiskHistoryStore{
+		dir:     dir,
+		tempDir: tempDir,
+		cacheAt: -1,
+	}, nil
+}
+
+// Dir returns the directory where entries are stored
+func (s *DiskHistoryStore) Dir() string {
+	return s.dir
+}
+
+func (s *DiskHistoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.files)
+}
+
+func (s *DiskHistoryStore) Get(i int) (*HistoryEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if i < 0 || i >= len(s.files) {
+		return nil, errHistoryIndexOutOfRange
+	}
+
+	if s.cacheAt == i {
+		return s.cached, nil
+	}
+
+	e, err := s.load(s.files[i])
+	if err != nil {
+		return nil, err
+	}
+
+	s.cached = e
+	s.cacheAt = i
+	return e, nil
+}
+
+func (s *DiskHistoryStore) Set(i int, e *HistoryEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if i < 0 || i >= len(s.files) {
+		return errHistoryIndexOutOfRange
+	}
+
+	fn, err := s.save(e)
+	if err != nil {
+		return err
+	}
+	os.Remove(s.files[i])
+	s.files[i] = fn
+
+	s.cached = e
+	s.cacheAt = i
+	return nil
+}
+
+func (s *DiskHistoryStore) Append(e *HistoryEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fn, err := s.save(e)
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, fn)
+
+	s.cached = e
+	s.cacheAt = len(s.files) - 1
+	return nil
+}
+
+func (s *DiskHistoryStore) Truncate(n int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if n < 0 || n > len(s.files) {
+		return errHistoryIndexOutOfRange
+	}
+
+	for _, fn := range s.files[n:] {
+		os.Remove(fn)
+	}
+	s.files = s.files[:n]
+
+	if s.cacheAt >= n {
+		s.cached = nil
+		s.cacheAt = -1
+	}
+	return nil
+}
+
+func (s *DiskHistoryStore) Evict(n int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if n < 0 || n > len(s.files) {
+		return errHistoryIndexOutOfRange
+	}
+
+	for _, fn := range s.files[:n] {
+		os.Remove(fn)
+	}
+	s.files = append([]string(nil), s.files[n:]...)
+
+	if s.cacheAt >= n {
+		s.cacheAt -= n
+	} else {
+		s.cached = nil
+		s.cacheAt = -1
+	}
+	return nil
+}
+
+// Close removes all entries from the disk, as well as the directory if
+// it was created by NewDiskHistoryStore
+func (s *DiskHistoryStore) Close() error {
+	if err := s.Truncate(0); err != nil {
+		return err
+	}
+
+	if s.tempDir {
+		return os.RemoveAll(s.dir)
+	}
+	return nil
+}
+
+func (s *DiskHistoryStore) save(e *HistoryEntry) (string, error) {
+	de := diskHistoryEntry{}
+	if req := e.request; req != nil {
+		de.Method = req.Method
+		de.URL = req.URL.String()
+		de.Header = req.Header
+		if isMultipart(req) {
+			// reading the body back would read all of the uploaded
+			// files into memory
+			de.BodyOmitted = true
+		} else if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return "", err
+			}
+			buf, err := ioutil.ReadAll(body)
+			body.Close()
+			if err != nil {
+				return "", err
+			}
+			de.Body = buf
+		}
+	}
+
+	if res := e.response; res != nil {
+		if res.Request != nil {
+			de.ResponseURL = res.Request.URL.String()
+		}
+		de.Status = res.Status
+		de.StatusCode = res.StatusCode
+		de.Proto = res.Proto
+		de.ProtoMajor = res.ProtoMajor
+		de.ProtoMinor = res.ProtoMinor
+		de.ResponseHeader = res.Header
+		de.RawBody = res.rawBody
+	}
+
+	// file names must be unique, as other stores (or a previous run)
+	// may be using the same directory
+	f, err := ioutil.TempFile(s.dir, "*.gob")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := gob.NewEncoder(f).Encode(de); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// isMultipart returns true if req has a multipart/form-data body
+func isMultipart(req *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mt == contentTypeMultipartFormData
+}
+
+func (s *DiskHistoryStore) load(fn string) (*HistoryEntry, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	de := diskHistoryEntry{}
+	if err := gob.NewDecoder(f).Decode(&de); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(de.Method, de.URL, bytes.NewReader(de.Body))
+	if err != nil {
+		return nil, err
+	}
+	if de.Header != nil {
+		req.Header = de.Header
+	}
+	if de.BodyOmitted {
+		// the body cannot be sent again, see Mechanize.Reload
+		req.Body = ioutil.NopCloser(bytes.NewReader(nil))
+		req.GetBody = nil
+		req.ContentLength = -1
+	}
+
+	resreq := req
+	if de.ResponseURL != "" && de.ResponseURL != de.URL {
+		resreq, err = http.NewRequest("GET", de.ResponseURL, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &http.Response{
+		Status:        de.Status,
+		StatusCode:    de.StatusCode,
+		Proto:         de.Proto,
+		ProtoMajor:    de.ProtoMajor,
+		ProtoMinor:    de.ProtoMinor,
+		Header:        de.ResponseHeader,
+		ContentLength: int64(len(de.RawBody)),
+		Body:          ioutil.NopCloser(bytes.NewReader(de.RawBody)),
+		Request:       resreq,
+	}
+	if res.Header == nil {
+		res.Header = http.Header{}
+	}
+
+	return &HistoryEntry{
+		request:  req,
+		response: NewResponse(nil, res),
+	}, nil
+}
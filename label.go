@@ -0,0 +1,157 @@
+package mechanize
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// isLabelable returns true if n is an element that can be associated
+// with a <label>
+func isLabelable(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+
+	switch n.Data {
+	case "button", "meter", "output", "progress", "select", "textarea":
+		return true
+	case "input":
+		v, _ := getAttr(n, "type")
+		return !strings.EqualFold(v, "hidden")
+	}
+	return false
+}
+
+// labelText returns the text of a label with whitespace collapsed. The
+// text of labelable descendants (e.g. the options of a <select> inside
+// the label) is not included
+func labelText(n *html.Node) string {
+	var buf bytes.Buffer
+	var fn func(*html.Node)
+	fn = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			buf.WriteString(n.Data)
+		case isLabelable(n):
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			fn(c)
+		}
+	}
+	fn(n)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// labeledControl returns the control that the label is associated with.
+// If the label has a for attribute, this is the labelable element with
+// that id. Otherwise it is the first labelable descendant
+func labeledControl(label *html.Node, ids map[string]*html.Node) *html.Node {
+	if id, ok := getAttr(label, "for"); ok {
+		if n := ids[id]; n != nil && isLabelable(n) {
+			return n
+		}
+		return nil
+	}
+
+	var found *html.Node
+	var fn func(*html.Node)
+	fn = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && found == nil; c = c.NextSibling {
+			if isLabelable(c) {
+				found = c
+				return
+			}
+			fn(c)
+		}
+	}
+	fn(label)
+	return found
+}
+
+// fieldLabels returns a map of control to the texts that label it, using
+// <label> elements in the document and aria-labelledby attributes
+func fieldLabels(root *html.Node) map[*html.Node][]string {
+	ids := collectIDs(root)
+	labels := map[*html.Node][]string{}
+
+	var fn func(*html.Node)
+	fn = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.Data == "label" {
+				if c := labeledControl(n, ids); c != nil {
+					labels[c] = append(labels[c], labelText(n))
+				}
+			}
+
+			if v, ok := getAttr(n, "aria-labelledby"); ok {
+				var texts []string
+				for _, id := range strings.Fields(v) {
+					if ref := ids[id]; ref != nil {
+						texts = append(texts, labelText(ref))
+					}
+				}
+				if len(texts) > 0 {
+					labels[n] = append(labels[n], strings.Join(texts, " "))
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			fn(c)
+		}
+	}
+	fn(root)
+	return labels
+}
+
+// FindFieldByLabel finds the field labeled label. The label may be the
+// text of an associated <label> element (either via its for attribute, or
+// by wrapping the field), or the aria-label, aria-labelledby, placeholder
+// or id of the field. Whitespace in label texts is collapsed before
+// comparison. It is an error if more than one field matches
+func (f *Form) FindFieldByLabel(label string) (FormField, error) {
+	label = strings.Join(strings.Fields(label), " ")
+
+	root := f.Node
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	labels := fieldLabels(root)
+
+	var matches []FormField
+	for _, field := range f.fields {
+		n := field.RawNode()
+		if labelMatches(n, labels[n], label) {
+			matches = append(matches, field)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, errors.New("no field labeled '" + label + "'")
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("label '%s' is ambiguous: matches %d fields", label, len(matches))
+	}
+}
+
+func labelMatches(n *html.Node, texts []string, label string) bool {
+	for _, text := range texts {
+		if text == label {
+			return true
+		}
+	}
+
+	for _, key := range []string{"aria-label", "placeholder", "id"} {
+		if v, ok := getAttr(n, key); ok && strings.Join(strings.Fields(v), " ") == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package mechanize
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lestrrat/go-mechanize/query"
+	"golang.org/x/net/html"
+)
+
+// Link represents a link found in a page. Links are collected from
+// <a>, <area>, <frame>, <iframe>, <link> and <meta http-equiv="refresh">
+// elements
+type Link struct {
+	*html.Node
+	response *Response
+	rawURL   string
+	text     string
+}
+
+// NewLink creates a new Link from n. If n does not contain a link,
+// nil is returned
+func NewLink(r *Response, n *html.Node) *Link {
+	if n.Type != html.ElementNode {
+		return nil
+	}
+
+	var rawURL, text string
+	var ok bool
+	switch n.Data {
+	case "a":
+		rawURL, ok = getAttr(n, "href")
+		text = strings.Join(strings.Fields(textContent(n)), " ")
+	case "area":
+		rawURL, ok = getAttr(n, "href")
+		text, _ = getAttr(n, "alt")
+	case "link":
+		rawURL, ok = getAttr(n, "href")
+	case "frame", "iframe":
+		rawURL, ok = getAttr(n, "src")
+	case "meta":
+		if v, _ := getAttr(n, "http-equiv"); strings.EqualFold(v, "refresh") {
+			content, _ := getAttr(n, "content")
+			rawURL, ok = parseMetaRefresh(content)
+		}
+	}
+
+	if !ok {
+		return nil
+	}
+
+	return &Link{
+		Node:     n,
+		response: r,
+		rawURL:   strings.TrimSpace(rawURL),
+		text:     text,
+	}
+}
+
+// parseMetaRefresh extracts the URL from the content attribute of
+// a <meta http-equiv="refresh"> element, e.g. "5; url=/foo"
+func parseMetaRefresh(content string) (string, bool) {
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return "", false
+	}
+
+	rest := strings.TrimSpace(content[i+1:])
+	if len(rest) >= 3 && strings.EqualFold(rest[:3], "url") {
+		rest = strings.TrimSpace(rest[3:])
+		if !strings.HasPrefix(rest, "=") {
+			return "", false
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+
+	if l := len(rest); l >= 2 && (rest[0] == '\'' || rest[0] == '"') {
+		if end := strings.IndexByte(rest[1:], rest[0]); end >= 0 {
+			rest = rest[1 : end+1]
+		} else {
+			rest = rest[1:]
+		}
+	}
+
+	if rest == "" {
+		return "", false
+	}
+	return rest, true
+}
+
+// RawURL returns the URL as it was written in the document
+func (l *Link) RawURL() string {
+	return l.rawURL
+}
+
+// URL returns the URL of the link, resolved against the <base> element
+// of the document or the URL of the request. If the URL cannot be
+// parsed, nil is returned
+func (l *Link) URL() *url.URL {
+	u, err := url.Parse(l.rawURL)
+	if err != nil {
+		return nil
+	}
+
+	if l.response == nil {
+		return u
+	}
+	return l.response.ResolveURL(u)
+}
+
+// Text returns the text of the link. For <a> elements this is the text
+// content with whitespace collapsed, for <area> elements this is the alt
+// attribute. Other elements do not have any text
+func (l *Link) Text() string {
+	return l.text
+}
+
+// Tag returns the name of the element that the link was found in
+func (l *Link) Tag() string {
+	return l.Data
+}
+
+func (l *Link) Title() string {
+	v, _ := getAttr(l.Node, "title")
+	return v
+}
+
+func (l *Link) Rel() string {
+	v, _ := getAttr(l.Node, "rel")
+	return v
+}
+
+func (l *Link) Name() string {
+	v, _ := getAttr(l.Node, "name")
+	return v
+}
+
+func (l *Link) ID() string {
+	v, _ := getAttr(l.Node, "id")
+	return v
+}
+
+// LinkCriteria specifies the conditions used to find a link, similar to
+// WWW::Mechanize's find_link(). All non-empty conditions must match
+type LinkCriteria struct {
+	// Text matches the text of the link exactly
+	Text string
+	// TextRegex matches against the text of the link
+	TextRegex *regexp.Regexp
+	// URL matches the URL of the link as written in the document
+	URL string
+	// URLRegex matches against the URL of the link as written in the
+	// document
+	URLRegex *regexp.Regexp
+	// URLAbsRegex matches against the resolved, absolute URL of the link
+	URLAbsRegex *regexp.Regexp
+	// Selector is a query selector that the link element must match
+	Selector string
+	// ID matches the id attribute of the link element
+	ID string
+	// Class matches one of the classes of the link element
+	Class string
+	// Rel matches the rel attribute of the link element
+	Rel string
+	// Tag matches the name of the link element, e.g. "a" or "iframe"
+	Tag string
+	// N selects the Nth (starting from 1) link that matches all other
+	// conditions. 0 is the same as 1
+	N int
+}
+
+func (c *LinkCriteria) match(l *Link) bool {
+	if c.Text != "" && l.Text() != c.Text {
+		return false
+	}
+
+	if c.TextRegex != nil && !c.TextRegex.MatchString(l.Text()) {
+		return false
+	}
+
+	if c.URL != "" && l.RawURL() != c.URL {
+		return false
+	}
+
+	if c.URLRegex != nil && !c.URLRegex.MatchString(l.RawURL()) {
+		return false
+	}
+
+	if c.URLAbsRegex != nil {
+		u := l.URL()
+		if u == nil || !c.URLAbsRegex.MatchString(u.String()) {
+			return false
+		}
+	}
+
+	if c.ID != "" && l.ID() != c.ID {
+		return false
+	}
+
+	if c.Class != "" && !hasClass(l.Node, c.Class) {
+		return false
+	}
+
+	if c.Rel != "" && l.Rel() != c.Rel {
+		return false
+	}
+
+	if c.Tag != "" && l.Tag() != c.Tag {
+		return false
+	}
+
+	return true
+}
+
+// FindAllLinks returns all links in the page that match c. The N field
+// of the criteria is ignored. An error is returned if the Selector
+// cannot be parsed
+func (r *Response) FindAllLinks(c LinkCriteria) ([]*Link, error) {
+	var selected map[*html.Node]struct{}
+	if c.Selector != "" {
+		sels, err := query.Compile(c.Selector)
+		if err != nil {
+			return nil, err
+		}
+
+		selected = map[*html.Node]struct{}{}
+		if r.parsedHTML != nil {
+			for _, n := range query.MatchNodes(r.parsedHTML, sels) {
+				selected[n] = struct{}{}
+			}
+		}
+	}
+
+	ret := []*Link{}
+	for _, l := range r.links {
+		if selected != nil {
+			if _, ok := selected[l.Node]; !ok {
+				continue
+			}
+		}
+
+		if c.match(l) {
+			ret = append(ret, l)
+		}
+	}
+	return ret, nil
+}
+
+// FindLink returns the link in the page that matches c
+func (r *Response) FindLink(c LinkCriteria) (*Link, error) {
+	n := c.N
+	if n <= 0 {
+		n = 1
+	}
+
+	links, err := r.FindAllLinks(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) < n {
+		return nil, errors.New("link not found")
+	}
+	return links[n-1], nil
+}
+
+// FollowLink finds the link in the current page that matches c, and
+// sends a GET request to its URL
+func (m *Mechanize) FollowLink(c LinkCriteria) error {
+	res := m.LastResponse()
+	if res == nil {
+		return errors.New("No response available")
+	}
+
+	l, err := res.FindLink(c)
+	if err != nil {
+		return err
+	}
+
+	u := l.URL()
+	if u == nil {
+		return errors.New("link has an invalid url")
+	}
+
+	return m.Get(u.String())
+}
@@ -13,18 +13,23 @@ import (
 
 const version = "0.0.1"
 
-type historyEnt struct {
-	request  *http.Request
-	response *Response
-}
-
 type Mechanize struct {
-	history     []*historyEnt
+	history HistoryStore
+	cursor  int
+	// current is the entry at cursor. It is kept, so that changes made
+	// to the forms of the current page are not lost when the store
+	// re-creates entries (see DiskHistoryStore)
+	current     *HistoryEntry
+	stackDepth  int
 	Agent       string
 	Client      *http.Client
 	CookieJar   *cookiejar.Jar
 	Headers     http.Header
 	SendReferer bool
+	// StrictForms makes Form.SetValue and friends return an error when
+	// changing fields that a user cannot change in a browser: disabled,
+	// readonly and hidden fields. Use Form.ForceValue to bypass
+	StrictForms bool
 }
 
 func New() *Mechanize {
@@ -37,6 +42,7 @@ func New() *Mechanize {
 		CookieJar: cjar,
 		Client:    &http.Client{},
 		Headers:   http.Header{},
+		history:   NewMemoryHistoryStore(),
 	}
 
 	m.Client.Jar = m.CookieJar
@@ -44,6 +50,55 @@ func New() *Mechanize {
 	return m
 }
 
+// SetStackDepth sets the maximum number of entries kept in the history,
+// including the current page. Older entries are evicted once the limit is
+// exceeded, followed by entries ahead of the current page if that is not
+// enough. A value less than or equal to 0 means unlimited (the default)
+func (m *Mechanize) SetStackDepth(depth int) error {
+	m.stackDepth = depth
+	return m.trimHistory()
+}
+
+// SetHistoryStore replaces the storage used for the history. Entries
+// recorded in the previous store are not carried over.
+func (m *Mechanize) SetHistoryStore(s HistoryStore) {
+	m.history = s
+	m.current = nil
+	m.cursor = s.Len() - 1
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+func (m *Mechanize) trimHistory() error {
+	if m.stackDepth <= 0 {
+		return nil
+	}
+
+	excess := m.history.Len() - m.stackDepth
+	if excess <= 0 {
+		return nil
+	}
+
+	// The current page is never evicted. Entries older than the current
+	// page go first, then entries that could be reached via Forward
+	evict := excess
+	if evict > m.cursor {
+		evict = m.cursor
+	}
+	if evict > 0 {
+		if err := m.history.Evict(evict); err != nil {
+			return err
+		}
+		m.cursor -= evict
+	}
+
+	if m.history.Len() > m.stackDepth {
+		return m.history.Truncate(m.stackDepth)
+	}
+	return nil
+}
+
 func (m *Mechanize) SetMaxRedirects(howmany int) {
 	m.Client.CheckRedirect = FollowRedirectsCallback(howmany)
 }
@@ -63,24 +118,14 @@ func FollowRedirectsCallback(howmany int) func(r *http.Request, via []*http.Requ
 	}
 }
 
+// ResolveURL resolves u against the current page. See Response.ResolveURL
 func (m *Mechanize) ResolveURL(u *url.URL) *url.URL {
 	res := m.LastResponse()
 	if res == nil {
 		return u
 	}
 
-	// <base> in the content takes precedence
-	if res.IsHTML() {
-		if res.base != "" {
-			parsed, err := url.Parse(res.base)
-			if err == nil {
-				return parsed.ResolveReference(u)
-			}
-		}
-	}
-
-	// finally, use the URL from the latest request
-	return res.Request.URL.ResolveReference(u)
+	return res.ResolveURL(u)
 }
 
 func (m *Mechanize) BuildRequest(method, u string, body io.Reader) (*http.Request, error) {
@@ -95,8 +140,8 @@ func (m *Mechanize) BuildRequest(method, u string, body io.Reader) (*http.Reques
 	}
 
 	referer := ""
-	if m.SendReferer && len(m.history) > 0 {
-		referer = m.history[0].response.Request.URL.String()
+	if res := m.LastResponse(); m.SendReferer && res != nil {
+		referer = res.Request.URL.String()
 	}
 
 	req.Header = m.Headers
@@ -144,7 +189,36 @@ func (m *Mechanize) Head(u string) error {
 	return m.SendRequest(req)
 }
 
+// SendRequest sends the request, and pushes the result to the history.
+// Any entries that were ahead of the current position in the history
+// (i.e. those that could be reached via Forward) are discarded, just like
+// when you navigate to a new page in a browser.
 func (m *Mechanize) SendRequest(req *http.Request) error {
+	res, err := m.do(req)
+
+	if m.history.Len() > 0 {
+		if serr := m.history.Truncate(m.cursor + 1); serr != nil {
+			return serr
+		}
+	}
+	e := &HistoryEntry{
+		request:  req,
+		response: res,
+	}
+	if serr := m.history.Append(e); serr != nil {
+		return serr
+	}
+	m.cursor = m.history.Len() - 1
+	m.current = e
+
+	if serr := m.trimHistory(); serr != nil {
+		return serr
+	}
+
+	return err
+}
+
+func (m *Mechanize) do(req *http.Request) (*Response, error) {
 	res, err := m.Client.Do(req)
 	if err != nil {
 		hdr := http.Header{}
@@ -164,32 +238,127 @@ func (m *Mechanize) SendRequest(req *http.Request) error {
 		}
 	}
 
-	m.history = append(m.history, &historyEnt{
+	return NewResponse(m, res), err
+}
+
+// Back moves the current position in the history one step back, so
+// that the previous page becomes the current page. No request is sent.
+func (m *Mechanize) Back() error {
+	if m.history.Len() <= 0 || m.cursor <= 0 {
+		return errors.New("no previous page in history")
+	}
+
+	m.cursor--
+	m.current = nil
+	return nil
+}
+
+// Forward moves the current position in the history one step forward,
+// undoing the effect of a previous call to Back. No request is sent.
+func (m *Mechanize) Forward() error {
+	if m.cursor+1 >= m.history.Len() {
+		return errors.New("no next page in history")
+	}
+
+	m.cursor++
+	m.current = nil
+	return nil
+}
+
+// Reload sends the request for the current page again, and replaces the
+// current history entry with the result. The rest of the history
+// is not altered. If the request fails, the current entry is kept
+// and the error is returned.
+func (m *Mechanize) Reload() error {
+	prev := m.LastRequest()
+	if prev == nil {
+		return errors.New("no page to reload")
+	}
+
+	req := prev.Clone(prev.Context())
+	if prev.Body != nil && prev.Body != http.NoBody && prev.GetBody == nil {
+		return errors.New("request body cannot be sent again")
+	}
+	if prev.GetBody != nil {
+		body, err := prev.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body = body
+	}
+
+	res, err := m.do(req)
+	if err != nil {
+		return err
+	}
+
+	e := &HistoryEntry{
 		request:  req,
-		response: NewResponse(m, res),
-	})
+		response: res,
+	}
+	if err := m.history.Set(m.cursor, e); err != nil {
+		return err
+	}
+	m.current = e
+	return nil
+}
 
-	return err
+// History returns the list of recorded history entries, oldest first.
+// Entries that were moved past with Back are included as well.
+func (m *Mechanize) History() ([]*HistoryEntry, error) {
+	ret := make([]*HistoryEntry, m.history.Len())
+	for i := range ret {
+		e, err := m.entry(i)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = e
+	}
+	return ret, nil
+}
+
+func (m *Mechanize) entry(i int) (*HistoryEntry, error) {
+	if i == m.cursor && m.current != nil {
+		return m.current, nil
+	}
+
+	e, err := m.history.Get(i)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.response != nil {
+		e.response.attach(m)
+	}
+	if i == m.cursor {
+		m.current = e
+	}
+	return e, nil
 }
 
-// LastRequest returns the most recent *http.Request. If there are no
-// request/response recorded, then the this method returns nil
+// LastRequest returns the *http.Request for the current page in the
+// history. If there are no request/response recorded, then the this
+// method returns nil
 func (m *Mechanize) LastRequest() *http.Request {
-	if len(m.history) <= 0 {
+	e, err := m.entry(m.cursor)
+	if err != nil {
 		return nil
 	}
 
-	return m.history[len(m.history)-1].request
+	return e.request
 }
 
-// LastResponse returns the most recent *Response. If there are no
-// request/response recorded, then the this method returns nil
+// LastResponse returns the *Response for the current page in the
+// history. If there are no request/response recorded, then the this
+// method returns nil. The same *Response (and therefore the same forms)
+// is returned until another page becomes the current page
 func (m *Mechanize) LastResponse() *Response {
-	if len(m.history) <= 0 {
+	e, err := m.entry(m.cursor)
+	if err != nil {
 		return nil
 	}
 
-	return m.history[len(m.history)-1].response
+	return e.response
 }
 
 func (m *Mechanize) LastError() error {
@@ -208,3 +377,27 @@ func (m *Mechanize) LastError() error {
 
 	return nil
 }
+
+// SubmitForm finds the form in the current page that matches c, fills
+// it with values (see Form.Fill), and submits it. If button is not empty,
+// the form is submitted by clicking that button (see Form.Click)
+func (m *Mechanize) SubmitForm(c FormCriteria, values map[string]interface{}, button string) error {
+	res := m.LastResponse()
+	if res == nil {
+		return errors.New("No response available")
+	}
+
+	f, err := res.FindForm(c)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Fill(values); err != nil {
+		return err
+	}
+
+	if button != "" {
+		return f.Click(button)
+	}
+	return f.Submit()
+}
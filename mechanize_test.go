@@ -3,11 +3,18 @@ package mechanize
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
 )
 
 func ExampleMechanize() {
@@ -60,15 +67,329 @@ const (
 		<input type="submit" value="Login">
 	</form>
 </body>
+</html>`
+	linksContent = `
+<html>
+<head>
+	<title>Links</title>
+	<base href="/base/">
+	<link rel="stylesheet" href="style.css">
+	<meta http-equiv="refresh" content="5; url='/refreshed'">
+</head>
+<body>
+	<a href="page1" title="First Page" id="first">  Page
+	One </a>
+	<a name="anchor">no href</a>
+	<a href="/form1" rel="nofollow" class="form-link">Form</a>
+	<map><area href="http://example.com/area" alt="Area"></map>
+	<iframe src="frame.html"></iframe>
+</body>
 </html>`
 )
 
+// testPages are static HTML pages served by the test server, keyed by path
+var testPages = map[string]string{
+	"/latin1": `
+<html>
+<head><meta charset="iso-8859-1"></head>
+<body>
+	<form action="/raw" method="POST">
+		<input type="text" name="q" value="café">
+	</form>
+</body>
+</html>`,
+	"/charset": `
+<html>
+<body>
+	<form action="/raw" method="POST" class="doc">
+		<input type="text" name="名前" value="日本">
+		<input type="hidden" name="_charset_">
+	</form>
+	<form action="/raw" method="POST" accept-charset="bogus EUC-JP" class="accept">
+		<input type="text" name="q" value="日本">
+	</form>
+	<form action="/upload" method="POST" enctype="multipart/form-data" class="multipart">
+		<input type="text" name="title" value="日本">
+	</form>
+</body>
+</html>`,
+	"/forms": `
+<html>
+<body>
+	<form name="search" action="/search" method="GET">
+		<input type="text" name="q">
+	</form>
+	<form id="login" action="/login" method="POST">
+		<input type="text" name="username">
+		<input type="password" name="password">
+	</form>
+	<form name="signup" action="/form1" method="POST">
+		<input type="text" name="username">
+		<input type="text" name="email">
+		<input type="submit" name="go" value="Sign up">
+	</form>
+</body>
+</html>`,
+	"/reset": `
+<html>
+<body>
+	<form action="/form1" method="POST">
+		<input type="text" name="title" value="hello">
+		<input type="checkbox" name="remember" checked>
+		<select name="city"><option>Tokyo</option><option selected>Osaka</option></select>
+		<textarea name="bio">line</textarea>
+		<input type="file" name="avatar">
+		<input type="text" name="same" value="x">
+	</form>
+</body>
+</html>`,
+	"/fill": `
+<html>
+<body>
+	<form action="/upload" method="POST" enctype="multipart/form-data">
+		<input type="text" name="title">
+		<input type="checkbox" name="remember">
+		<select name="docs" multiple><option>a</option><option>b</option><option>c</option></select>
+		<input type="file" name="avatar">
+		<input type="number" name="none">
+		<select name="nums" multiple><option>1</option><option>2</option><option>3</option></select>
+		<input type="text" name="ratio">
+	</form>
+</body>
+</html>`,
+	"/validate": `
+<html>
+<body>
+	<form action="/form1" method="POST">
+		<input type="text" name="name" required>
+		<input type="text" name="code" pattern="[A-Z]{3}" value="abc">
+		<input type="password" name="pass" minlength="8" value="short">
+		<textarea name="bio" maxlength="5">too long</textarea>
+		<input type="number" name="age" min="18" max="99" value="17">
+		<input type="number" name="qty" min="0" step="5" value="7">
+		<input type="number" name="price" value="9.99">
+		<input type="email" name="email" value="not-an-email">
+		<input type="url" name="site" value="example.com">
+		<input type="date" name="day" min="2020-01-01" value="2019-12-31">
+		<input type="checkbox" name="agree" required>
+		<input type="radio" name="plan" value="a" required>
+		<input type="radio" name="plan" value="b">
+		<select name="pref" required><option value="">Choose</option><option>Tokyo</option></select>
+		<input type="text" name="off" required disabled>
+		<input type="submit" name="skip" value="Skip" formnovalidate>
+	</form>
+</body>
+</html>`,
+	"/labels": `
+<html>
+<body>
+	<form action="/form1" method="POST">
+		<label for="email">Email
+			address</label>
+		<input type="text" id="email" name="mail">
+		<label>Country
+			<select name="country"><option>Japan</option></select>
+		</label>
+		<input type="text" name="q" aria-label="Search">
+		<input type="text" name="nick" placeholder="Nickname">
+		<span id="phone-label">Phone</span>
+		<input type="text" name="tel" aria-labelledby="phone-label">
+		<input type="text" name="zip" id="zipcode">
+		<label for="a">Duplicate</label><input type="text" id="a" name="a">
+		<label for="b">Duplicate</label><input type="text" id="b" name="b">
+	</form>
+</body>
+</html>`,
+	"/multi": `
+<html>
+<body>
+	<form action="/raw" method="POST">
+		<input type="hidden" name="token" value="a">
+		<input type="text" name="tags[]" value="go">
+		<input type="text" name="tags[]" value="perl">
+		<input type="hidden" name="token" value="b">
+		<input type="checkbox" name="opt" value="x">
+		<input type="checkbox" name="opt" value="y">
+		<select name="lang" multiple>
+			<option>en</option>
+			<option>ja</option>
+		</select>
+	</form>
+</body>
+</html>`,
+	"/disabled": `
+<html>
+<body>
+	<form action="/form1" method="POST">
+		<input type="text" name="enabled" value="1">
+		<input type="text" name="off" value="2" disabled>
+		<input type="text" name="ro" value="3" readonly>
+		<input type="hidden" name="token" value="4">
+		<textarea name="rotext" readonly>5</textarea>
+		<fieldset disabled>
+			<legend><input type="text" name="legend" value="6"></legend>
+			<input type="text" name="inset" value="7">
+			<input type="submit" name="inset-submit" value="8">
+		</fieldset>
+		<input type="submit" name="off-submit" value="9" disabled>
+	</form>
+</body>
+</html>`,
+	"/owner": `
+<html>
+<body>
+	<input type="text" name="before" value="1" form="main">
+	<form id="main" action="/form1" method="POST">
+		<input type="text" name="inside" value="2">
+		<input type="text" name="stolen" value="x" form="other">
+	</form>
+	<form id="other" action="/form1" method="POST">
+		<input type="text" name="own" value="y">
+	</form>
+	<div class="footer">
+		<textarea name="notes" form="main">3</textarea>
+		<select name="choice" form="main"><option>4</option></select>
+		<input type="text" name="orphan" value="z" form="nowhere">
+		<button name="go" value="5" form="main" class="ext">Go</button>
+	</div>
+</body>
+</html>`,
+	"/method": `
+<html>
+<body>
+	<form action="/echo?drop=me#frag" class="get">
+		<input type="text" name="q" value="go">
+	</form>
+	<form method="post" class="noaction">
+		<input type="text" name="q" value="go">
+	</form>
+	<form method="dialog" class="dialog">
+		<input type="text" name="q" value="go">
+	</form>
+	<form method="bogus" action="echo" class="bogus">
+		<input type="text" name="q" value="go">
+	</form>
+</body>
+</html>`,
+	"/upload": `
+<html>
+<body>
+	<form action="/upload" method="POST" enctype="multipart/form-data">
+		<input type="text" name="title" value="hello">
+		<input type="file" name="avatar">
+		<input type="file" name="docs" multiple>
+		<input type="file" name="none">
+	</form>
+</body>
+</html>`,
+	"/buttons": `
+<html>
+<body>
+	<form action="/form1" method="POST">
+		<input type="text" name="q" value="go">
+		<input type="submit" name="op" value="Search">
+		<input type="submit" name="op" value="Lucky" id="lucky" formaction="/echo" formmethod="get">
+		<button name="action" value="save" class="save">Save</button>
+		<button type="button" name="noop" value="x">Nothing</button>
+		<input type="reset" name="reset" value="Reset">
+		<input type="image" name="map" value="ignored" src="map.png" alt="Map">
+		<input type="image" src="go.png" alt="Go" class="anon">
+	</form>
+</body>
+</html>`,
+	"/checkable": `
+<html>
+<body>
+	<form action="/form1" method="POST">
+		<input type="checkbox" name="agree">
+		<input type="checkbox" name="news" value="weekly" checked>
+		<input type="checkbox" name="news" value="monthly">
+		<input type="radio" name="plan" value="free" checked>
+		<input type="radio" name="plan" value="pro">
+		<input type="radio" name="plan" value="enterprise" checked>
+		<input type="radio" name="unpicked" value="x">
+	</form>
+</body>
+</html>`,
+	"/textarea": `
+<html>
+<body>
+	<form action="/form1" method="POST">
+		<textarea name="comment">
+first line
+second &amp; line</textarea>
+		<textarea name="empty"></textarea>
+	</form>
+</body>
+</html>`,
+	"/select": `
+<html>
+<body>
+	<form action="/form1" method="POST">
+		<select name="color">
+			<option value="r">Red</option>
+			<option value="g" selected>Green</option>
+			<option value="b" disabled>Blue</option>
+		</select>
+		<select name="size">
+			<option>Small</option>
+			<option label="Large">L</option>
+		</select>
+		<select name="tags" multiple>
+			<option value="a" selected>A</option>
+			<option value="b">B</option>
+			<optgroup label="More" disabled>
+				<option value="c">C</option>
+			</optgroup>
+			<option value="d" selected>D</option>
+		</select>
+	</form>
+</body>
+</html>`,
+}
+
 func startTestServer(t *testing.T) *testServer {
 	return newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Logf("Access detected to %s", r.URL.Path)
 		switch r.URL.Path {
 		case "/page1":
 			io.WriteString(w, page1Content)
+		case "/links":
+			io.WriteString(w, linksContent)
+		case "/upload":
+			if r.Method == "GET" {
+				io.WriteString(w, testPages["/upload"])
+				return
+			}
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			for _, k := range []string{"title", "avatar", "docs", "none"} {
+				for _, v := range r.MultipartForm.Value[k] {
+					fmt.Fprintf(w, "%s=%s\n", k, v)
+				}
+				for _, fh := range r.MultipartForm.File[k] {
+					f, _ := fh.Open()
+					buf, _ := ioutil.ReadAll(f)
+					f.Close()
+					fmt.Fprintf(w, "%s=%s:%s:%s\n", k, fh.Filename, fh.Header.Get("Content-Type"), buf)
+				}
+			}
+		case "/raw":
+			buf, _ := ioutil.ReadAll(r.Body)
+			w.Write(buf)
+		case "/latin1":
+			// no charset in the header, so the <meta> element is used
+			w.Header().Set("Content-Type", "text/html")
+			page, _ := charmap.ISO8859_1.NewEncoder().String(testPages["/latin1"])
+			io.WriteString(w, page)
+		case "/sjis":
+			w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+			page, _ := japanese.ShiftJIS.NewEncoder().String(testPages["/charset"])
+			io.WriteString(w, page)
+		case "/echo":
+			io.WriteString(w, r.Method+" "+r.URL.RawQuery)
 		case "/form1":
 			r.ParseForm()
 			io.WriteString(w, r.Form.Encode())
@@ -129,6 +450,16 @@ func startTestServer(t *testing.T) *testServer {
 			w.Header().Set("Location", u)
 			w.WriteHeader(302)
 		default:
+			if r.Method == "POST" {
+				r.ParseForm()
+				io.WriteString(w, "POST "+r.URL.Path+" "+r.PostForm.Encode())
+				return
+			}
+			if content, ok := testPages[r.URL.Path]; ok {
+				w.Header().Set("Content-Type", "text/html")
+				io.WriteString(w, content)
+				return
+			}
 			http.Error(w, "Not Found", 404)
 		}
 	}))
@@ -294,3 +625,1832 @@ func TestFormParse(t *testing.T) {
 		return
 	}
 }
+
+func TestHistory(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	if err := m.Back(); err == nil {
+		t.Errorf("Back should fail on empty history")
+		return
+	}
+
+	if err := m.Get(ts0.URLFor("/page1", nil)); err != nil {
+		t.Errorf("Failed to fetch /page1: %s", err)
+		return
+	}
+
+	v := url.Values{"username": []string{"johndoe"}}
+	if err := m.PostForm("/form1", v); err != nil {
+		t.Errorf("Failed to post /form1: %s", err)
+		return
+	}
+
+	if err := m.Back(); err != nil {
+		t.Errorf("Back failed: %s", err)
+		return
+	}
+
+	if p := m.LastRequest().URL.Path; p != "/page1" {
+		t.Errorf("Expected current page to be /page1, got %s", p)
+		return
+	}
+
+	if len(m.LastResponse().Forms()) != 1 {
+		t.Errorf("Expected forms from /page1 to be available after Back")
+		return
+	}
+
+	if err := m.Forward(); err != nil {
+		t.Errorf("Forward failed: %s", err)
+		return
+	}
+
+	if p := m.LastRequest().URL.Path; p != "/form1" {
+		t.Errorf("Expected current page to be /form1, got %s", p)
+		return
+	}
+
+	if err := m.Reload(); err != nil {
+		t.Errorf("Reload failed: %s", err)
+		return
+	}
+
+	if buf := m.LastResponse().RawBody(); string(buf) != v.Encode() {
+		t.Errorf("Expected reloaded body to be '%s', got '%s'", v.Encode(), buf)
+		return
+	}
+
+	h, err := m.History()
+	if err != nil {
+		t.Errorf("History failed: %s", err)
+		return
+	}
+	if l := len(h); l != 2 {
+		t.Errorf("Expected 2 history entries after Reload, got %d", l)
+		return
+	}
+
+	// Navigating from a previous page discards the forward history
+	m.Back()
+	if err := m.Get("/page1"); err != nil {
+		t.Errorf("Failed to fetch /page1: %s", err)
+		return
+	}
+
+	h, err = m.History()
+	if err != nil {
+		t.Errorf("History failed: %s", err)
+		return
+	}
+	if l := len(h); l != 2 {
+		t.Errorf("Expected 2 history entries, got %d", l)
+		return
+	}
+
+	if err := m.Forward(); err == nil {
+		t.Errorf("Forward should fail at the end of history")
+		return
+	}
+}
+
+func TestHistoryReloadFailure(t *testing.T) {
+	ts0 := startTestServer(t)
+
+	m := New()
+	if err := m.Get(ts0.URLFor("/page1", nil)); err != nil {
+		t.Errorf("Failed to fetch /page1: %s", err)
+		return
+	}
+
+	ts0.Close()
+	if err := m.Reload(); err == nil {
+		t.Errorf("Reload should fail when the server is gone")
+		return
+	}
+
+	// the page that was being reloaded is kept
+	if buf := m.LastResponse().RawBody(); string(buf) != page1Content {
+		t.Errorf("Expected current page to be kept, got '%s'", buf)
+		return
+	}
+
+	if len(m.LastResponse().Forms()) != 1 {
+		t.Errorf("Expected forms from /page1 to be available after a failed Reload")
+		return
+	}
+}
+
+func TestHistoryStackDepth(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	disk, err := NewDiskHistoryStore("")
+	if err != nil {
+		t.Errorf("Failed to create disk history store: %s", err)
+		return
+	}
+	defer disk.Close()
+
+	for _, store := range []HistoryStore{NewMemoryHistoryStore(), disk} {
+		m := New()
+		m.SetHistoryStore(store)
+		m.SetStackDepth(2)
+
+		for _, p := range []string{"/page1", "/form1", "/page1"} {
+			if err := m.Get(ts0.URLFor(p, nil)); err != nil {
+				t.Errorf("Failed to fetch %s: %s", p, err)
+				return
+			}
+		}
+
+		if l := store.Len(); l != 2 {
+			t.Errorf("Expected 2 history entries, got %d", l)
+			return
+		}
+
+		if err := m.Back(); err != nil {
+			t.Errorf("Back failed: %s", err)
+			return
+		}
+
+		if p := m.LastRequest().URL.Path; p != "/form1" {
+			t.Errorf("Expected current page to be /form1, got %s", p)
+			return
+		}
+
+		if err := m.Back(); err == nil {
+			t.Errorf("Back should fail past the stack depth")
+			return
+		}
+
+		if err := m.Forward(); err != nil {
+			t.Errorf("Forward failed: %s", err)
+			return
+		}
+
+		forms := m.LastResponse().Forms()
+		if len(forms) != 1 {
+			t.Errorf("Expected 1 form, got %d", len(forms))
+			return
+		}
+
+		forms[0].SetValue("username", "johndoe")
+		if err := forms[0].Submit(); err != nil {
+			t.Errorf("Failed to submit form: %s", err)
+			return
+		}
+
+		if buf := m.LastResponse().RawBody(); !strings.Contains(string(buf), "username=johndoe") {
+			t.Errorf("Got something else from server: %s", buf)
+			return
+		}
+	}
+}
+
+func TestHistoryStackDepthKeepsCurrent(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+	for _, p := range []string{"/form1?a=1", "/page1", "/form1?a=2", "/links"} {
+		if err := m.Get(ts0.URLFor(p, nil)); err != nil {
+			t.Errorf("Failed to fetch %s: %s", p, err)
+			return
+		}
+	}
+
+	m.Back()
+	m.Back()
+	if err := m.SetStackDepth(1); err != nil {
+		t.Errorf("SetStackDepth failed: %s", err)
+		return
+	}
+
+	if p := m.LastRequest().URL.Path; p != "/page1" {
+		t.Errorf("Expected current page to be /page1, got %s", p)
+		return
+	}
+
+	h, err := m.History()
+	if err != nil {
+		t.Errorf("History failed: %s", err)
+		return
+	}
+	if l := len(h); l != 1 {
+		t.Errorf("Expected 1 history entry, got %d", l)
+		return
+	}
+
+	// the limit is kept when the history grows
+	m.SetStackDepth(2)
+	m.Get("/links")
+	m.Get("/form1")
+	if l := m.history.Len(); l != 2 {
+		t.Errorf("Expected 2 history entries, got %d", l)
+		return
+	}
+}
+
+func TestHistoryCurrentResponseStable(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	disk, err := NewDiskHistoryStore("")
+	if err != nil {
+		t.Errorf("Failed to create disk history store: %s", err)
+		return
+	}
+	defer disk.Close()
+
+	m := New()
+	m.SetHistoryStore(disk)
+	for _, p := range []string{"/links", "/page1"} {
+		if err := m.Get(ts0.URLFor(p, nil)); err != nil {
+			t.Errorf("Failed to fetch %s: %s", p, err)
+			return
+		}
+	}
+
+	r1 := m.LastResponse()
+	r1.Forms()[0].SetValue("username", "johndoe")
+
+	if _, err := m.History(); err != nil {
+		t.Errorf("History failed: %s", err)
+		return
+	}
+
+	r2 := m.LastResponse()
+	if r1 != r2 {
+		t.Errorf("Expected the same response for the current page")
+		return
+	}
+
+	if err := r2.Forms()[0].Submit(); err != nil {
+		t.Errorf("Failed to submit form: %s", err)
+		return
+	}
+
+	if buf := m.LastResponse().RawBody(); !strings.Contains(string(buf), "username=johndoe") {
+		t.Errorf("Expected form changes to be kept, got %s", buf)
+		return
+	}
+}
+
+func TestDiskHistoryStoreSharedDir(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	dir, err := ioutil.TempDir("", "go-mechanize-test")
+	if err != nil {
+		t.Errorf("Failed to create directory: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	var ms []*Mechanize
+	for _, p := range []string{"/page1", "/form1"} {
+		store, err := NewDiskHistoryStore(dir)
+		if err != nil {
+			t.Errorf("Failed to create disk history store: %s", err)
+			return
+		}
+		defer store.Close()
+
+		m := New()
+		m.SetHistoryStore(store)
+		for _, u := range []string{p, "/links"} {
+			if err := m.Get(ts0.URLFor(u, nil)); err != nil {
+				t.Errorf("Failed to fetch %s: %s", u, err)
+				return
+			}
+		}
+		ms = append(ms, m)
+	}
+
+	for i, p := range []string{"/page1", "/form1"} {
+		if err := ms[i].Back(); err != nil {
+			t.Errorf("Back failed: %s", err)
+			return
+		}
+
+		if got := ms[i].LastRequest().URL.Path; got != p {
+			t.Errorf("Expected current page to be %s, got %s", p, got)
+			return
+		}
+	}
+
+	// temporary directories are removed on Close
+	store, err := NewDiskHistoryStore("")
+	if err != nil {
+		t.Errorf("Failed to create disk history store: %s", err)
+		return
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Failed to close disk history store: %s", err)
+		return
+	}
+	if _, err := os.Stat(store.Dir()); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed", store.Dir())
+		return
+	}
+}
+
+func TestDiskHistoryStoreMultipart(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	dir, err := ioutil.TempDir("", "go-mechanize-test")
+	if err != nil {
+		t.Errorf("Failed to create directory: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "avatar.png")
+	if err := ioutil.WriteFile(path, []byte("PNG!"), 0600); err != nil {
+		t.Errorf("Failed to create file: %s", err)
+		return
+	}
+
+	store, err := NewDiskHistoryStore("")
+	if err != nil {
+		t.Errorf("Failed to create disk history store: %s", err)
+		return
+	}
+	defer store.Close()
+
+	m := New()
+	m.SetHistoryStore(store)
+
+	u := ts0.URLFor("/upload", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	f.SetValue("avatar", path)
+	if err := f.Submit(); err != nil {
+		t.Errorf("Failed to submit form: %s", err)
+		return
+	}
+	expected := string(m.LastResponse().RawBody())
+
+	if err := m.Get(ts0.URLFor("/page1", nil)); err != nil {
+		t.Errorf("Failed to fetch /page1: %s", err)
+		return
+	}
+
+	// the multipart body is not saved, so the entry read back from the
+	// disk cannot be reloaded
+	if err := m.Back(); err != nil {
+		t.Errorf("Back failed: %s", err)
+		return
+	}
+	if err := m.Reload(); err == nil {
+		t.Errorf("Reloading a multipart entry read from the disk should fail")
+		return
+	}
+	if buf := m.LastResponse().RawBody(); string(buf) != expected {
+		t.Errorf("Expected the page to be kept after a failed reload, got '%s'", buf)
+		return
+	}
+}
+
+func TestLinks(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/links", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	links := m.LastResponse().Links()
+	expected := []struct {
+		tag  string
+		url  string
+		text string
+	}{
+		{"link", ts0.URLFor("/base/style.css", nil), ""},
+		{"meta", ts0.URLFor("/refreshed", nil), ""},
+		{"a", ts0.URLFor("/base/page1", nil), "Page One"},
+		{"a", ts0.URLFor("/form1", nil), "Form"},
+		{"area", "http://example.com/area", "Area"},
+		{"iframe", ts0.URLFor("/base/frame.html", nil), ""},
+	}
+
+	if len(links) != len(expected) {
+		t.Errorf("Expected %d links, got %d", len(expected), len(links))
+		return
+	}
+
+	for i, e := range expected {
+		l := links[i]
+		if l.Tag() != e.tag {
+			t.Errorf("links[%d]: expected tag %s, got %s", i, e.tag, l.Tag())
+		}
+		if got := l.URL().String(); got != e.url {
+			t.Errorf("links[%d]: expected url %s, got %s", i, e.url, got)
+		}
+		if l.Text() != e.text {
+			t.Errorf("links[%d]: expected text '%s', got '%s'", i, e.text, l.Text())
+		}
+	}
+
+	if title := links[2].Title(); title != "First Page" {
+		t.Errorf("Expected title 'First Page', got '%s'", title)
+	}
+
+	if rel := links[3].Rel(); rel != "nofollow" {
+		t.Errorf("Expected rel 'nofollow', got '%s'", rel)
+	}
+}
+
+func TestFollowLink(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	tests := []LinkCriteria{
+		{Text: "Form"},
+		{TextRegex: regexp.MustCompile(`^F`)},
+		{URLRegex: regexp.MustCompile(`form`)},
+		{URLAbsRegex: regexp.MustCompile(`^http://.+/form1$`)},
+		{Selector: "a.form-link"},
+		{Class: "form-link"},
+		{Rel: "nofollow"},
+		{Tag: "a", N: 2},
+	}
+
+	for _, c := range tests {
+		u := ts0.URLFor("/links", nil)
+		if err := m.Get(u); err != nil {
+			t.Errorf("Failed to fetch %s: %s", u, err)
+			return
+		}
+
+		if err := m.FollowLink(c); err != nil {
+			t.Errorf("FollowLink failed for %#v: %s", c, err)
+			return
+		}
+
+		if p := m.LastRequest().URL.Path; p != "/form1" {
+			t.Errorf("Expected to follow link to /form1 for %#v, got %s", c, p)
+			return
+		}
+	}
+
+	if err := m.Get("/links"); err != nil {
+		t.Errorf("Failed to fetch /links: %s", err)
+		return
+	}
+
+	if err := m.FollowLink(LinkCriteria{Tag: "a", N: 3}); err == nil {
+		t.Errorf("FollowLink should fail when there is no such link")
+		return
+	}
+
+	if err := m.FollowLink(LinkCriteria{Selector: "a[href="}); err == nil || err.Error() == "link not found" {
+		t.Errorf("FollowLink should report the syntax error, got %v", err)
+		return
+	}
+
+	if err := m.FollowLink(LinkCriteria{ID: "first"}); err != nil {
+		t.Errorf("FollowLink failed: %s", err)
+		return
+	}
+
+	if p := m.LastRequest().URL.Path; p != "/base/page1" {
+		t.Errorf("Expected to follow link to /base/page1, got %s", p)
+		return
+	}
+}
+
+func TestFormSelect(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/select", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	fv, err := f.FormValues()
+	if err != nil {
+		t.Errorf("failed to encode form values: %s", err)
+		return
+	}
+
+	expected := url.Values{
+		"color": []string{"g"},
+		"size":  []string{"Small"},
+		"tags":  []string{"a", "d"},
+	}
+	if fv.Encode() != expected.Encode() {
+		t.Errorf("expected default values '%s', got '%s'", expected.Encode(), fv.Encode())
+		return
+	}
+
+	if err := f.SetValue("color", "b"); err == nil {
+		t.Errorf("selecting a disabled option should fail")
+		return
+	}
+
+	if err := f.SetValue("color", "purple"); err == nil {
+		t.Errorf("selecting a non-existent option should fail")
+		return
+	}
+
+	if err := f.SetValue("color", "Red"); err != nil {
+		t.Errorf("failed to select option by label: %s", err)
+		return
+	}
+
+	if err := f.SetValue("size", "L"); err != nil {
+		t.Errorf("failed to select option by value: %s", err)
+		return
+	}
+
+	field, err := f.FindField("tags")
+	if err != nil {
+		t.Errorf("failed to find field tags: %s", err)
+		return
+	}
+
+	tags := field.(*Select)
+	if err := tags.SelectByValue("c"); err == nil {
+		t.Errorf("selecting an option in a disabled optgroup should fail")
+		return
+	}
+
+	if err := tags.SelectByLabel("B", "D"); err != nil {
+		t.Errorf("failed to select options by label: %s", err)
+		return
+	}
+
+	field, _ = f.FindField("color")
+	if err := field.(*Select).SelectByValue("r", "g"); err == nil {
+		t.Errorf("selecting multiple options in a single select should fail")
+		return
+	}
+
+	fv, _ = f.FormValues()
+	expected = url.Values{
+		"color": []string{"r"},
+		"size":  []string{"L"},
+		"tags":  []string{"b", "d"},
+	}
+	if fv.Encode() != expected.Encode() {
+		t.Errorf("expected values '%s', got '%s'", expected.Encode(), fv.Encode())
+		return
+	}
+}
+
+func TestFormTextArea(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/textarea", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	field, err := f.FindField("comment")
+	if err != nil {
+		t.Errorf("failed to find field comment: %s", err)
+		return
+	}
+
+	if v := field.Value(); v != "first line\nsecond & line" {
+		t.Errorf("unexpected initial value '%s'", v)
+		return
+	}
+
+	fv, err := f.FormValues()
+	if err != nil {
+		t.Errorf("failed to encode form values: %s", err)
+		return
+	}
+
+	if v := fv.Get("comment"); v != "first line\r\nsecond & line" {
+		t.Errorf("expected newlines to be normalized to CRLF, got %q", v)
+		return
+	}
+
+	if _, ok := fv["empty"]; !ok {
+		t.Errorf("expected empty textarea to be submitted")
+		return
+	}
+
+	f.SetValue("comment", "a\rb\r\nc\nd")
+	if v := field.Value(); v != "a\nb\nc\nd" {
+		t.Errorf("expected value to be normalized to LF, got %q", v)
+		return
+	}
+
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit form: %s", err)
+		return
+	}
+
+	expected := url.Values{
+		"comment": []string{"a\r\nb\r\nc\r\nd"},
+		"empty":   []string{""},
+	}
+	if buf := m.LastResponse().RawBody(); string(buf) != expected.Encode() {
+		t.Errorf("Got something else from server: %s", buf)
+		return
+	}
+}
+
+func TestFormCheckable(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/checkable", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	fv, err := f.FormValues()
+	if err != nil {
+		t.Errorf("failed to encode form values: %s", err)
+		return
+	}
+
+	expected := url.Values{
+		"news": []string{"weekly"},
+		"plan": []string{"enterprise"},
+	}
+	if fv.Encode() != expected.Encode() {
+		t.Errorf("expected default values '%s', got '%s'", expected.Encode(), fv.Encode())
+		return
+	}
+
+	field, err := f.FindField("agree")
+	if err != nil {
+		t.Errorf("failed to find field agree: %s", err)
+		return
+	}
+
+	agree := field.(*Input)
+	if agree.IsChecked() {
+		t.Errorf("agree should not be checked")
+		return
+	}
+	if v := agree.Value(); v != "on" {
+		t.Errorf("expected default value 'on', got '%s'", v)
+		return
+	}
+	agree.Check()
+
+	if err := f.SetValue("plan", "pro"); err != nil {
+		t.Errorf("failed to select radio button: %s", err)
+		return
+	}
+
+	if err := f.SetValue("plan", "platinum"); err == nil {
+		t.Errorf("selecting a non-existent radio button should fail")
+		return
+	}
+
+	if err := f.Check("news", "monthly"); err != nil {
+		t.Errorf("failed to check checkbox: %s", err)
+		return
+	}
+
+	if err := f.Uncheck("news", "weekly"); err != nil {
+		t.Errorf("failed to uncheck checkbox: %s", err)
+		return
+	}
+
+	fv, _ = f.FormValues()
+	expected = url.Values{
+		"agree": []string{"on"},
+		"news":  []string{"monthly"},
+		"plan":  []string{"pro"},
+	}
+	if fv.Encode() != expected.Encode() {
+		t.Errorf("expected values '%s', got '%s'", expected.Encode(), fv.Encode())
+		return
+	}
+}
+
+func TestFormClick(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	tests := []struct {
+		sel      string
+		expected string
+	}{
+		{"", "op=Search&q=go"},
+		{"action", "action=save&q=go"},
+		{"button.save", "action=save&q=go"},
+		{"map", "map.x=0&map.y=0&q=go"},
+		{"input.anon", "q=go&x=0&y=0"},
+	}
+
+	for _, test := range tests {
+		u := ts0.URLFor("/buttons", nil)
+		if err := m.Get(u); err != nil {
+			t.Errorf("Failed to fetch %s: %s", u, err)
+			return
+		}
+
+		f := m.LastResponse().Forms()[0]
+		if err := f.Click(test.sel); err != nil {
+			t.Errorf("failed to click '%s': %s", test.sel, err)
+			return
+		}
+
+		if buf := m.LastResponse().RawBody(); string(buf) != test.expected {
+			t.Errorf("clicking '%s': expected '%s', got '%s'", test.sel, test.expected, buf)
+			return
+		}
+	}
+
+	if err := m.Get("/buttons"); err != nil {
+		t.Errorf("Failed to fetch /buttons: %s", err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	if err := f.Click("noop"); err == nil {
+		t.Errorf("clicking a non-submit button should fail")
+		return
+	}
+
+	b, err := f.FindSubmitter("op")
+	if err != nil {
+		t.Errorf("failed to find submitter: %s", err)
+		return
+	}
+	if b.Value() != "Search" {
+		t.Errorf("expected first submit button named 'op', got '%s'", b.Value())
+		return
+	}
+
+	fv, _ := f.FormValues()
+	if fv.Encode() != "q=go" {
+		t.Errorf("expected buttons to be excluded from values, got '%s'", fv.Encode())
+		return
+	}
+
+	// formaction and formmethod on the submitter override the form
+	if err := f.Click("#lucky"); err != nil {
+		t.Errorf("failed to click '#lucky': %s", err)
+		return
+	}
+
+	if buf := m.LastResponse().RawBody(); string(buf) != "GET q=go&op=Lucky" {
+		t.Errorf("expected 'GET q=go&op=Lucky', got '%s'", buf)
+		return
+	}
+}
+
+func TestFormClickAt(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/buttons", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	if err := f.ClickAt("map", 10, 20); err != nil {
+		t.Errorf("failed to click 'map': %s", err)
+		return
+	}
+
+	if buf := m.LastResponse().RawBody(); string(buf) != "map.x=10&map.y=20&q=go" {
+		t.Errorf("expected 'map.x=10&map.y=20&q=go', got '%s'", buf)
+		return
+	}
+
+	// coordinates are ignored for other buttons
+	if err := f.ClickAt("action", 10, 20); err != nil {
+		t.Errorf("failed to click 'action': %s", err)
+		return
+	}
+
+	if buf := m.LastResponse().RawBody(); string(buf) != "action=save&q=go" {
+		t.Errorf("expected 'action=save&q=go', got '%s'", buf)
+		return
+	}
+
+	// image buttons are not submitted unless clicked
+	for _, e := range f.Entries() {
+		if strings.HasSuffix(e.Name, "x") || strings.HasSuffix(e.Name, "y") {
+			t.Errorf("unexpected entry %s", e.Name)
+			return
+		}
+	}
+}
+
+func TestFormMultipart(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	dir, err := ioutil.TempDir("", "go-mechanize-test")
+	if err != nil {
+		t.Errorf("failed to create temporary directory: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "avatar.png")
+	if err := ioutil.WriteFile(path, []byte("PNG!"), 0600); err != nil {
+		t.Errorf("failed to create file: %s", err)
+		return
+	}
+
+	m := New()
+
+	u := ts0.URLFor("/upload", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	if err := f.SetValue("avatar", path); err != nil {
+		t.Errorf("failed to set file path: %s", err)
+		return
+	}
+
+	if err := f.SetFile("title", &UploadFile{Data: []byte("x")}); err == nil {
+		t.Errorf("setting a file on a text input should fail")
+		return
+	}
+
+	err = f.SetFile("docs",
+		&UploadFile{Filename: "a.json", Data: []byte("{}")},
+		&UploadFile{Filename: "b.bin", ContentType: "application/x-custom", Reader: strings.NewReader("world")},
+	)
+	if err != nil {
+		t.Errorf("failed to set files: %s", err)
+		return
+	}
+
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit form: %s", err)
+		return
+	}
+
+	expected := "title=hello\n" +
+		"avatar=avatar.png:image/png:PNG!\n" +
+		"docs=a.json:application/json:{}\n" +
+		"docs=b.bin:application/x-custom:world\n" +
+		"none=\n"
+	if buf := m.LastResponse().RawBody(); string(buf) != expected {
+		t.Errorf("expected '%s', got '%s'", expected, buf)
+		return
+	}
+
+	// files read from an io.Reader cannot be sent again
+	if err := m.Reload(); err == nil {
+		t.Errorf("reloading an upload from an io.Reader should fail")
+		return
+	}
+	if buf := m.LastResponse().RawBody(); string(buf) != expected {
+		t.Errorf("expected the page to be kept after a failed reload, got '%s'", buf)
+		return
+	}
+
+	m.Back()
+	f = m.LastResponse().Forms()[0]
+	f.SetValue("avatar", path)
+	f.SetFile("docs", &UploadFile{Filename: "a.json", Data: []byte("{}")})
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit form: %s", err)
+		return
+	}
+
+	if err := m.Reload(); err != nil {
+		t.Errorf("failed to reload: %s", err)
+		return
+	}
+
+	expected = "title=hello\n" +
+		"avatar=avatar.png:image/png:PNG!\n" +
+		"docs=a.json:application/json:{}\n" +
+		"none=\n"
+	if buf := m.LastResponse().RawBody(); string(buf) != expected {
+		t.Errorf("expected '%s' after reload, got '%s'", expected, buf)
+		return
+	}
+}
+
+func TestFormMethod(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	tests := []struct {
+		sel      string
+		expected string
+	}{
+		{"form.get", "GET q=go"},
+		{"form.noaction", "POST /method q=go"},
+		{"form.bogus", "GET q=go"},
+	}
+
+	for _, test := range tests {
+		u := ts0.URLFor("/method", nil)
+		if err := m.Get(u); err != nil {
+			t.Errorf("Failed to fetch %s: %s", u, err)
+			return
+		}
+
+		f, err := m.LastResponse().Form(test.sel)
+		if err != nil {
+			t.Errorf("failed to find form %s: %s", test.sel, err)
+			return
+		}
+
+		if err := f.Submit(); err != nil {
+			t.Errorf("failed to submit %s: %s", test.sel, err)
+			return
+		}
+
+		if buf := m.LastResponse().RawBody(); string(buf) != test.expected {
+			t.Errorf("submitting %s: expected '%s', got '%s'", test.sel, test.expected, buf)
+			return
+		}
+	}
+
+	if err := m.Get("/method"); err != nil {
+		t.Errorf("Failed to fetch /method: %s", err)
+		return
+	}
+
+	res := m.LastResponse()
+	f, _ := res.Form("form.dialog")
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit dialog form: %s", err)
+		return
+	}
+
+	if m.LastResponse() != res {
+		t.Errorf("submitting a dialog form should not send a request")
+		return
+	}
+
+	// The action is resolved against the page that contained the form,
+	// not the current page
+	f, _ = res.Form("form.noaction")
+	if err := m.Get("/links"); err != nil {
+		t.Errorf("Failed to fetch /links: %s", err)
+		return
+	}
+
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit form: %s", err)
+		return
+	}
+
+	if buf := m.LastResponse().RawBody(); string(buf) != "POST /method q=go" {
+		t.Errorf("expected 'POST /method q=go', got '%s'", buf)
+		return
+	}
+}
+
+func TestFormOwner(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/owner", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f, err := m.LastResponse().Form("#main")
+	if err != nil {
+		t.Errorf("failed to find form #main: %s", err)
+		return
+	}
+
+	expected := url.Values{
+		"before": []string{"1"},
+		"inside": []string{"2"},
+		"notes":  []string{"3"},
+		"choice": []string{"4"},
+		"go":     []string{"5"},
+	}
+
+	// buttons outside of the form can be clicked by name or by selector
+	for _, sel := range []string{"go", "button.ext", "div.footer > button"} {
+		if err := f.Click(sel); err != nil {
+			t.Errorf("failed to click button outside of form with '%s': %s", sel, err)
+			return
+		}
+
+		if buf := m.LastResponse().RawBody(); string(buf) != expected.Encode() {
+			t.Errorf("expected '%s', got '%s'", expected.Encode(), buf)
+			return
+		}
+		m.Back()
+	}
+	m.Forward()
+
+	m.Back()
+	f, err = m.LastResponse().Form("#other")
+	if err != nil {
+		t.Errorf("failed to find form #other: %s", err)
+		return
+	}
+
+	fv, _ := f.FormValues()
+	expected = url.Values{
+		"own":    []string{"y"},
+		"stolen": []string{"x"},
+	}
+	if fv.Encode() != expected.Encode() {
+		t.Errorf("expected '%s', got '%s'", expected.Encode(), fv.Encode())
+		return
+	}
+}
+
+func TestFormDisabled(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/disabled", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	fv, err := f.FormValues()
+	if err != nil {
+		t.Errorf("failed to encode form values: %s", err)
+		return
+	}
+
+	expected := url.Values{
+		"enabled": []string{"1"},
+		"ro":      []string{"3"},
+		"token":   []string{"4"},
+		"rotext":  []string{"5"},
+		"legend":  []string{"6"},
+	}
+	if fv.Encode() != expected.Encode() {
+		t.Errorf("expected '%s', got '%s'", expected.Encode(), fv.Encode())
+		return
+	}
+
+	for _, name := range []string{"inset-submit", "off-submit"} {
+		if err := f.Click(name); err == nil {
+			t.Errorf("clicking disabled button %s should fail", name)
+			return
+		}
+	}
+
+	// Not strict: anything goes
+	if err := f.SetValue("ro", "x"); err != nil {
+		t.Errorf("failed to set readonly field: %s", err)
+		return
+	}
+
+	m.StrictForms = true
+	for _, name := range []string{"off", "ro", "token", "rotext", "inset"} {
+		if err := f.SetValue(name, "x"); err == nil {
+			t.Errorf("setting %s in strict mode should fail", name)
+			return
+		}
+	}
+
+	if err := f.SetValue("enabled", "x"); err != nil {
+		t.Errorf("failed to set field: %s", err)
+		return
+	}
+
+	if err := f.ForceValue("token", "forced"); err != nil {
+		t.Errorf("failed to force hidden field: %s", err)
+		return
+	}
+
+	fv, _ = f.FormValues()
+	if v := fv.Get("token"); v != "forced" {
+		t.Errorf("expected token to be 'forced', got '%s'", v)
+		return
+	}
+}
+
+func TestFormMultiValued(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/multi", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	if v := f.Values("token"); len(v) != 2 || v[0] != "a" || v[1] != "b" {
+		t.Errorf("expected token values [a b], got %v", v)
+		return
+	}
+
+	if err := f.SetValues("tags[]", "rust", "c", "d"); err == nil {
+		t.Errorf("setting more values than fields should fail")
+		return
+	}
+
+	if err := f.SetValues("tags[]", "rust", "c"); err != nil {
+		t.Errorf("failed to set values: %s", err)
+		return
+	}
+
+	if err := f.SetValues("opt", "y"); err != nil {
+		t.Errorf("failed to set checkbox values: %s", err)
+		return
+	}
+
+	for _, kv := range [][2]string{{"opt", "x"}, {"lang", "ja"}, {"lang", "en"}, {"extra", "1"}, {"extra", "2"}} {
+		if err := f.AddValue(kv[0], kv[1]); err != nil {
+			t.Errorf("failed to add value %s=%s: %s", kv[0], kv[1], err)
+			return
+		}
+	}
+
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit form: %s", err)
+		return
+	}
+
+	expected := "token=a&tags%5B%5D=rust&tags%5B%5D=c&token=b&opt=x&opt=y&lang=en&lang=ja&extra=1&extra=2"
+	if buf := m.LastResponse().RawBody(); string(buf) != expected {
+		t.Errorf("expected '%s', got '%s'", expected, buf)
+		return
+	}
+}
+
+func TestFormFindFieldByLabel(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/labels", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	tests := map[string]string{
+		"Email address": "mail",
+		"Country":       "country",
+		"Search":        "q",
+		"Nickname":      "nick",
+		"Phone":         "tel",
+		"zipcode":       "zip",
+	}
+
+	for label, name := range tests {
+		field, err := f.FindFieldByLabel(label)
+		if err != nil {
+			t.Errorf("failed to find field labeled '%s': %s", label, err)
+			return
+		}
+		if field.Name() != name {
+			t.Errorf("expected field labeled '%s' to be %s, got %s", label, name, field.Name())
+			return
+		}
+	}
+
+	if _, err := f.FindFieldByLabel("Duplicate"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("expected ambiguous label error, got %v", err)
+		return
+	}
+
+	if _, err := f.FindFieldByLabel("Nothing"); err == nil {
+		t.Errorf("finding a non-existent label should fail")
+		return
+	}
+}
+
+func TestFormValidate(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/validate", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	expected := []string{
+		"name:required",
+		"code:pattern",
+		"age:min",
+		"qty:step",
+		"email:type",
+		"site:type",
+		"day:min",
+		"agree:required",
+		"plan:required",
+		"pref:required",
+	}
+
+	violations := f.Validate()
+	if len(violations) != len(expected) {
+		t.Errorf("Expected %d violations, got %d: %v", len(expected), len(violations), violations)
+		return
+	}
+
+	for i, v := range violations {
+		if got := v.Field.Name() + ":" + v.Constraint; got != expected[i] {
+			t.Errorf("violations[%d]: expected %s, got %s", i, expected[i], got)
+		}
+	}
+
+	err := f.Submit()
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("expected *ValidationError from Submit, got %v", err)
+		return
+	}
+
+	// formnovalidate on the submitter skips validation
+	if err := f.Click("skip"); err != nil {
+		t.Errorf("expected formnovalidate to skip validation, got %s", err)
+		return
+	}
+
+	m.Back()
+	f = m.LastResponse().Forms()[0]
+
+	// lengths are only checked once the user has changed the value
+	f.SetValue("pass", "short2")
+	f.SetValue("bio", "too long!")
+	violations = f.Validate()
+	got := map[string]bool{}
+	for _, v := range violations {
+		got[v.Field.Name()+":"+v.Constraint] = true
+	}
+	if !got["pass:minlength"] || !got["bio:maxlength"] {
+		t.Errorf("expected length violations after editing, got %v", violations)
+		return
+	}
+
+	// without min, the step base is the value attribute, so an unchanged
+	// fractional default is valid, but other fractions are not
+	if got["price:step"] {
+		t.Errorf("expected default price to be valid, got %v", violations)
+		return
+	}
+	f.SetValue("price", "10.5")
+	for _, v := range f.Validate() {
+		got[v.Field.Name()+":"+v.Constraint] = true
+	}
+	if !got["price:step"] {
+		t.Errorf("expected step violation for price 10.5")
+		return
+	}
+
+	values := map[string]string{
+		"name":  "John",
+		"code":  "ABC",
+		"pass":  "long enough",
+		"bio":   "short",
+		"age":   "20",
+		"qty":   "10",
+		"price": "10.99",
+		"email": "john@example.com",
+		"site":  "http://example.com",
+		"day":   "2020-01-02",
+		"plan":  "b",
+		"pref":  "Tokyo",
+	}
+	for k, v := range values {
+		if err := f.SetValue(k, v); err != nil {
+			t.Errorf("failed to set %s: %s", k, err)
+			return
+		}
+	}
+	f.Check("agree", "on")
+
+	if violations := f.Validate(); len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+		return
+	}
+}
+
+func TestFormFill(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/fill", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	checkValues := func(f *Form, expected map[string]string) bool {
+		for name, v := range expected {
+			if got := strings.Join(f.Values(name), ","); got != v {
+				t.Errorf("expected %s to be '%s', got '%s'", name, v, got)
+				return false
+			}
+		}
+		return true
+	}
+
+	f := m.LastResponse().Forms()[0]
+	err := f.Fill(map[string]interface{}{
+		"title":    "hello",
+		"remember": true,
+		"docs":     []string{"a", "c"},
+		"avatar":   strings.NewReader("PNG!"),
+		"none":     42,
+		"unknown":  "x",
+	})
+
+	ferr, ok := err.(*FillError)
+	if !ok {
+		t.Errorf("expected *FillError, got %v", err)
+		return
+	}
+	if _, ok := ferr.Errors["unknown"]; !ok || len(ferr.Errors) != 1 {
+		t.Errorf("expected a single error for 'unknown', got %v", ferr.Errors)
+		return
+	}
+
+	if !checkValues(f, map[string]string{
+		"title":    "hello",
+		"remember": "on",
+		"docs":     "a,c",
+		"avatar":   "blob",
+		"none":     "42",
+	}) {
+		return
+	}
+
+	type embedded struct {
+		Remember bool `form:"remember"`
+	}
+	type params struct {
+		embedded
+		Title    string   `form:"title"`
+		Docs     []string `form:"docs"`
+		None     int      `form:"none,omitempty"`
+		Internal string   `form:"-"`
+		ignored  string
+	}
+
+	if err := m.Reload(); err != nil {
+		t.Errorf("Reload failed: %s", err)
+		return
+	}
+
+	f = m.LastResponse().Forms()[0]
+	p := params{
+		embedded: embedded{Remember: true},
+		Title:    "world",
+		Docs:     []string{"b"},
+		Internal: "x",
+		ignored:  "y",
+	}
+	if err := f.FillStruct(&p); err != nil {
+		t.Errorf("failed to fill struct: %s", err)
+		return
+	}
+
+	if !checkValues(f, map[string]string{
+		"title":    "world",
+		"remember": "on",
+		"docs":     "b",
+		"none":     "",
+	}) {
+		return
+	}
+
+	if err := f.FillStruct(struct{ Unknown string }{"x"}); err == nil {
+		t.Errorf("expected an error for the unknown field Unknown")
+		return
+	}
+
+	if err := f.FillStruct("not a struct"); err == nil {
+		t.Errorf("expected an error for a non-struct value")
+		return
+	}
+
+	// pointers are dereferenced, nil pointers are skipped, slices of
+	// numbers set multiple values and []byte is uploaded
+	if err := m.Reload(); err != nil {
+		t.Errorf("Reload failed: %s", err)
+		return
+	}
+
+	f = m.LastResponse().Forms()[0]
+	title := "pointer"
+	remember := true
+	err = f.FillStruct(struct {
+		Title    *string `form:"title"`
+		Remember *bool   `form:"remember"`
+		None     *int    `form:"none"`
+		Nums     []int   `form:"nums"`
+		Avatar   []byte  `form:"avatar"`
+		Ratio    float64 `form:"ratio"`
+	}{&title, &remember, nil, []int{1, 3}, []byte("hi"), 0.5})
+	if err != nil {
+		t.Errorf("failed to fill struct: %s", err)
+		return
+	}
+
+	if !checkValues(f, map[string]string{
+		"title":    "pointer",
+		"remember": "on",
+		"none":     "",
+		"nums":     "1,3",
+		"avatar":   "blob",
+		"ratio":    "0.5",
+	}) {
+		return
+	}
+
+	field, _ := f.FindField("avatar")
+	if files := field.(*Input).Files(); len(files) != 1 || string(files[0].Data) != "hi" {
+		t.Errorf("expected []byte to be uploaded as file data")
+		return
+	}
+
+	var nilTitle *string
+	if err := f.Fill(map[string]interface{}{"title": nilTitle}); err != nil {
+		t.Errorf("expected nil pointer to be skipped, got %s", err)
+		return
+	}
+	if v := f.Values("title"); len(v) != 1 || v[0] != "pointer" {
+		t.Errorf("expected nil pointer to leave the value untouched, got %v", v)
+		return
+	}
+
+	err = f.Fill(map[string]interface{}{
+		"title": map[string]string{},
+		"ratio": struct{}{},
+		"nums":  []interface{}{[]int{1}},
+	})
+	ferr, ok = err.(*FillError)
+	if !ok || len(ferr.Errors) != 3 {
+		t.Errorf("expected errors for unsupported types, got %v", err)
+		return
+	}
+}
+
+func TestFormSelection(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/forms", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	res := m.LastResponse()
+	forms := res.Forms()
+
+	tests := []struct {
+		name     string
+		find     func() (*Form, error)
+		expected *Form
+	}{
+		{"FormWithFields", func() (*Form, error) { return res.FormWithFields("username", "email") }, forms[2]},
+		{"FormByName", func() (*Form, error) { return res.FormByName("search") }, forms[0]},
+		{"FormByID", func() (*Form, error) { return res.FormByID("login") }, forms[1]},
+		{"FormByAction", func() (*Form, error) { return res.FormByAction(regexp.MustCompile(`/login$`)) }, forms[1]},
+		{"FormNumber", func() (*Form, error) { return res.FormNumber(3) }, forms[2]},
+		{"FindForm", func() (*Form, error) { return res.FindForm(FormCriteria{Fields: []string{"username"}, N: 2}) }, forms[2]},
+		{"Form", func() (*Form, error) { return res.Form("form[action='/login']") }, forms[1]},
+		{"Form", func() (*Form, error) { return res.Form("form:has(input[type=password])") }, forms[1]},
+		{"Form", func() (*Form, error) { return res.Form("form#nope, form[name=signup]") }, forms[2]},
+	}
+
+	for _, test := range tests {
+		f, err := test.find()
+		if err != nil {
+			t.Errorf("%s failed: %s", test.name, err)
+			return
+		}
+		if f != test.expected {
+			t.Errorf("%s returned the wrong form", test.name)
+			return
+		}
+	}
+
+	if _, err := res.FormWithFields("username", "nothing"); err == nil {
+		t.Errorf("FormWithFields should fail when no form has all fields")
+		return
+	}
+
+	if _, err := res.FormNumber(4); err == nil {
+		t.Errorf("FormNumber should fail for a non-existent form")
+		return
+	}
+
+	// syntax errors in the selector are reported, rather than treated
+	// as a selector that matches nothing
+	if _, err := res.Form("form["); err == nil || err.Error() == "specified for not found" {
+		t.Errorf("Form should report the syntax error, got %v", err)
+		return
+	}
+
+	if _, err := res.FindForm(FormCriteria{Selector: "form["}); err == nil || err.Error() == "form not found" {
+		t.Errorf("FindForm should report the syntax error, got %v", err)
+		return
+	}
+
+	if _, err := forms[2].FindSubmitter("button["); err == nil || err.Error() == "submit button not found" {
+		t.Errorf("FindSubmitter should report the syntax error, got %v", err)
+		return
+	}
+
+	err := m.SubmitForm(
+		FormCriteria{Name: "signup"},
+		map[string]interface{}{"username": "john", "email": "john@example.com"},
+		"go",
+	)
+	if err != nil {
+		t.Errorf("SubmitForm failed: %s", err)
+		return
+	}
+
+	expected := url.Values{
+		"username": []string{"john"},
+		"email":    []string{"john@example.com"},
+		"go":       []string{"Sign up"},
+	}
+	if buf := m.LastResponse().RawBody(); string(buf) != expected.Encode() {
+		t.Errorf("expected '%s', got '%s'", expected.Encode(), buf)
+		return
+	}
+}
+
+func TestFormCharset(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/sjis", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	res := m.LastResponse()
+	if cs := res.Charset(); cs != "shift_jis" {
+		t.Errorf("expected charset shift_jis, got %s", cs)
+		return
+	}
+
+	sjis := func(s string) string {
+		v, _ := japanese.ShiftJIS.NewEncoder().String(s)
+		return url.QueryEscape(v)
+	}
+	eucjp := func(s string) string {
+		v, _ := japanese.EUCJP.NewEncoder().String(s)
+		return url.QueryEscape(v)
+	}
+
+	tests := []struct {
+		sel      string
+		expected string
+	}{
+		{"form.doc", sjis("名前") + "=" + sjis("日本") + "&_charset_=shift_jis"},
+		{"form.accept", "q=" + eucjp("日本")},
+	}
+
+	for _, test := range tests {
+		f, err := res.Form(test.sel)
+		if err != nil {
+			t.Errorf("failed to find form %s: %s", test.sel, err)
+			return
+		}
+
+		// values are decoded from the document's encoding
+		if v := f.Values("q"); test.sel == "form.accept" && (len(v) != 1 || v[0] != "日本") {
+			t.Errorf("expected value to be decoded, got %v", v)
+			return
+		}
+
+		if err := f.Submit(); err != nil {
+			t.Errorf("failed to submit %s: %s", test.sel, err)
+			return
+		}
+
+		if buf := m.LastResponse().RawBody(); string(buf) != test.expected {
+			t.Errorf("submitting %s: expected '%s', got '%s'", test.sel, test.expected, buf)
+			return
+		}
+	}
+
+	f, _ := res.Form("form.multipart")
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit form.multipart: %s", err)
+		return
+	}
+
+	expected, _ := japanese.ShiftJIS.NewEncoder().String("title=日本\n")
+	if buf := m.LastResponse().RawBody(); string(buf) != expected {
+		t.Errorf("expected Shift_JIS encoded multipart value, got '%s'", buf)
+		return
+	}
+}
+
+func TestFormCharsetMeta(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/latin1", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	// ISO-8859-1 is treated as windows-1252, as browsers do
+	res := m.LastResponse()
+	if cs := res.Charset(); cs != "windows-1252" {
+		t.Errorf("expected charset windows-1252, got %s", cs)
+		return
+	}
+
+	f := res.Forms()[0]
+	if v := f.Values("q"); len(v) != 1 || v[0] != "café" {
+		t.Errorf("expected value to be decoded, got %q", v)
+		return
+	}
+
+	f.SetValue("q", "crème")
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit form: %s", err)
+		return
+	}
+
+	if buf := m.LastResponse().RawBody(); string(buf) != "q=cr%E8me" {
+		t.Errorf("expected 'q=cr%%E8me', got '%s'", buf)
+		return
+	}
+}
+
+func TestFormReset(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/reset", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	f := m.LastResponse().Forms()[0]
+	if changed := f.Changed(); len(changed) != 0 {
+		t.Errorf("expected no changed fields, got %d", len(changed))
+		return
+	}
+
+	f.SetValue("title", "bye")
+	f.Uncheck("remember", "on")
+	f.SetValue("city", "Tokyo")
+	f.SetValue("bio", "other")
+	f.SetFile("avatar", &UploadFile{Filename: "a.json", Data: []byte("{}")})
+	// setting the same value is not a change
+	f.SetValue("same", "x")
+	f.AddValue("extra", "1")
+
+	var names []string
+	for _, field := range f.Changed() {
+		names = append(names, field.Name())
+	}
+	if got := strings.Join(names, ","); got != "title,remember,city,bio,avatar,extra" {
+		t.Errorf("expected changed fields 'title,remember,city,bio,avatar,extra', got '%s'", got)
+		return
+	}
+
+	field, _ := f.FindField("title")
+	if v := field.(*Input).DefaultValue(); v != "hello" {
+		t.Errorf("expected default value 'hello', got '%s'", v)
+		return
+	}
+
+	f.Reset()
+	if changed := f.Changed(); len(changed) != 0 {
+		t.Errorf("expected no changed fields after reset, got %d", len(changed))
+		return
+	}
+
+	if _, err := f.FindField("extra"); err == nil {
+		t.Errorf("expected added field to be removed by reset")
+		return
+	}
+
+	if err := f.Submit(); err != nil {
+		t.Errorf("failed to submit form: %s", err)
+		return
+	}
+
+	expected := "avatar=&bio=line&city=Osaka&remember=on&same=x&title=hello"
+	if buf := m.LastResponse().RawBody(); string(buf) != expected {
+		t.Errorf("expected '%s', got '%s'", expected, buf)
+		return
+	}
+}
+
+func TestFormResetRadios(t *testing.T) {
+	ts0 := startTestServer(t)
+	defer ts0.Close()
+
+	m := New()
+
+	u := ts0.URLFor("/checkable", nil)
+	if err := m.Get(u); err != nil {
+		t.Errorf("Failed to fetch %s: %s", u, err)
+		return
+	}
+
+	// the page checks two radios named plan, and the last one wins
+	f := m.LastResponse().Forms()[0]
+	if changed := f.Changed(); len(changed) != 0 {
+		t.Errorf("expected no changed fields, got %d", len(changed))
+		return
+	}
+
+	if err := f.Check("plan", "free"); err != nil {
+		t.Errorf("failed to check plan: %s", err)
+		return
+	}
+
+	var names []string
+	for _, field := range f.Changed() {
+		names = append(names, field.Name()+"="+field.(*Input).Value())
+	}
+	if got := strings.Join(names, ","); got != "plan=free,plan=enterprise" {
+		t.Errorf("expected changed fields 'plan=free,plan=enterprise', got '%s'", got)
+		return
+	}
+
+	f.Reset()
+	if changed := f.Changed(); len(changed) != 0 {
+		t.Errorf("expected no changed fields after reset, got %d", len(changed))
+		return
+	}
+
+	fv, err := f.FormValues()
+	if err != nil {
+		t.Errorf("failed to encode form values: %s", err)
+		return
+	}
+	if v := fv["plan"]; len(v) != 1 || v[0] != "enterprise" {
+		t.Errorf("expected plan 'enterprise' after reset, got %v", v)
+		return
+	}
+}
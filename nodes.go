@@ -1,12 +1,96 @@
 package mechanize
 
 import (
+	"bytes"
 	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/lestrrat/go-mechanize/query"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
+// getAttr returns the value of the attribute key in n, and whether
+// the attribute was present
+func getAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// hasAttr returns true if n has the attribute key
+func hasAttr(n *html.Node, key string) bool {
+	_, ok := getAttr(n, key)
+	return ok
+}
+
+// hasClass returns true if name is one of the classes of n
+func hasClass(n *html.Node, name string) bool {
+	v, _ := getAttr(n, "class")
+	for _, c := range strings.Fields(v) {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// textContent returns the concatenated text of all text nodes under n
+func textContent(n *html.Node) string {
+	var buf bytes.Buffer
+	var fn func(*html.Node)
+	fn = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			fn(c)
+		}
+	}
+	fn(n)
+	return buf.String()
+}
+
+// isDisabled returns true if the control n is disabled, either by its
+// own disabled attribute, or by being inside a disabled <fieldset>.
+// Controls in the first <legend> of a disabled fieldset are not disabled
+func isDisabled(n *html.Node) bool {
+	if _, ok := getAttr(n, "disabled"); ok {
+		return true
+	}
+
+	for child, p := n, n.Parent; p != nil; child, p = p, p.Parent {
+		if p.Type != html.ElementNode || p.Data != "fieldset" {
+			continue
+		}
+		if _, ok := getAttr(p, "disabled"); !ok {
+			continue
+		}
+		if child.Type == html.ElementNode && child.Data == "legend" && child == firstLegend(p) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func firstLegend(fieldset *html.Node) *html.Node {
+	for c := fieldset.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "legend" {
+			return c
+		}
+	}
+	return nil
+}
+
 const (
 	contentTypeFormUrlEncoded    = "application/x-www-form-urlencoded"
 	contentTypeMultipartFormData = "multipart/form-data"
@@ -16,16 +100,32 @@ type FormField interface {
 	RawNode() *html.Node
 	Name() string
 	Value() string
-	SetValue(string)
+	SetValue(string) error
 }
 
 type Input struct {
 	*html.Node
+	checked bool
+	// defaultChecked is the checkedness when the document was parsed,
+	// after applying the radio button group rules
+	defaultChecked bool
+	files          []*UploadFile
+	group          []*Input
+	// value is the current value, once it has been changed from the
+	// value attribute
+	value string
+	dirty bool
+	// added is true if the input was added by Form.AddValue
+	added bool
+	// coordinates of the last click, for image buttons
+	x, y int
 }
 
 func NewInput(n *html.Node) *Input {
+	_, checked := getAttr(n, "checked")
 	return &Input{
-		Node: n,
+		Node:    n,
+		checked: checked,
 	}
 }
 
@@ -42,124 +142,1392 @@ func (i Input) Name() string {
 	return ""
 }
 
+// Type returns the lower cased type attribute. If the attribute is
+// missing, "text" is returned
+func (i Input) Type() string {
+	if v, ok := getAttr(i.Node, "type"); ok && v != "" {
+		return strings.ToLower(v)
+	}
+	return "text"
+}
+
+// IsCheckable returns true if the input is a checkbox or a radio button
+func (i Input) IsCheckable() bool {
+	switch i.Type() {
+	case "checkbox", "radio":
+		return true
+	}
+	return false
+}
+
+// IsChecked returns true if the input is a checkbox or a radio button,
+// and it is checked
+func (i Input) IsChecked() bool {
+	return i.IsCheckable() && i.checked
+}
+
+// Check checks the checkbox or radio button. Checking a radio button
+// unchecks all other radio buttons in the same group
+func (i *Input) Check() error {
+	if !i.IsCheckable() {
+		return errors.New("input '" + i.Name() + "' is not a checkbox or a radio button")
+	}
+
+	if i.Type() == "radio" {
+		for _, other := range i.group {
+			other.checked = false
+		}
+	}
+	i.checked = true
+	return nil
+}
+
+// Uncheck unchecks the checkbox or radio button
+func (i *Input) Uncheck() error {
+	if !i.IsCheckable() {
+		return errors.New("input '" + i.Name() + "' is not a checkbox or a radio button")
+	}
+
+	i.checked = false
+	return nil
+}
+
+// IsFile returns true if the input is a file upload field
+func (i Input) IsFile() bool {
+	return i.Type() == "file"
+}
+
+// Files returns the files to be uploaded
+func (i Input) Files() []*UploadFile {
+	return i.files
+}
+
+// SetFile sets the files to be uploaded. More than one file can only
+// be specified if the input has the multiple attribute
+func (i *Input) SetFile(files ...*UploadFile) error {
+	if !i.IsFile() {
+		return errors.New("input '" + i.Name() + "' is not a file upload field")
+	}
+
+	if _, multiple := getAttr(i.Node, "multiple"); !multiple && len(files) > 1 {
+		return errors.New("input '" + i.Name() + "' does not accept multiple files")
+	}
+
+	i.files = files
+	return nil
+}
+
+// Value returns the current value, which is the value attribute unless
+// it has been changed with SetValue. Checkboxes and radio buttons
+// without a value attribute have the value "on". For file upload fields,
+// this is the file name of the first file
 func (i Input) Value() string {
-	for _, attr := range i.Attr {
-		if attr.Key == "value" {
-			return attr.Val
+	if i.IsFile() {
+		if len(i.files) > 0 {
+			return i.files[0].filename()
 		}
+		return ""
+	}
+
+	if i.dirty {
+		return i.value
+	}
+	return i.DefaultValue()
+}
+
+// DefaultValue returns the value of the input as it was sent by the
+// server, i.e. the value attribute
+func (i Input) DefaultValue() string {
+	if v, ok := getAttr(i.Node, "value"); ok {
+		return v
+	}
+
+	if i.IsCheckable() {
+		return "on"
 	}
 	return ""
 }
 
-func (i *Input) SetValue(v string) {
-	for _, attr := range i.Attr {
-		if attr.Key == "value" {
-			attr.Val = v
-			return
-		}
+// SetValue sets the value of the input. The value attribute of the
+// node is left untouched, so that the form can be reset. For file
+// upload fields, v is the path of the file to upload
+func (i *Input) SetValue(v string) error {
+	if i.IsFile() {
+		return i.SetFile(&UploadFile{Path: v})
+	}
+
+	i.value = v
+	i.dirty = true
+	return nil
+}
+
+// IsDisabled returns true if the input is disabled. Disabled inputs
+// are never submitted
+func (i Input) IsDisabled() bool {
+	return isDisabled(i.Node)
+}
+
+// IsReadOnly returns true if the input has the readonly attribute, and
+// is of a type where readonly applies
+func (i Input) IsReadOnly() bool {
+	if _, ok := getAttr(i.Node, "readonly"); !ok {
+		return false
+	}
+
+	switch i.Type() {
+	case "checkbox", "radio", "file", "hidden", "range", "color", "submit", "image", "reset", "button":
+		return false
 	}
-	// It's possible that we have no such attribute.
-	// just create one in that case
-	i.Attr = append(i.Attr, html.Attribute{Key: "value", Val: v})
+	return true
 }
 
-type Form struct {
+// IsHidden returns true if the input is of type hidden
+func (i Input) IsHidden() bool {
+	return i.Type() == "hidden"
+}
+
+// IsButton returns true if the input is a button: submit, image, reset
+// or button. Buttons are never submitted unless used as the submitter
+func (i Input) IsButton() bool {
+	switch i.Type() {
+	case "submit", "image", "reset", "button":
+		return true
+	}
+	return false
+}
+
+// IsSubmit returns true if the input can be used to submit the form
+func (i Input) IsSubmit() bool {
+	switch i.Type() {
+	case "submit", "image":
+		return true
+	}
+	return false
+}
+
+// IsImage returns true if the input is an image button
+func (i Input) IsImage() bool {
+	return i.Type() == "image"
+}
+
+// Button is a <button> element
+type Button struct {
 	*html.Node
-	mechanize *Mechanize
-	action    string
-	enctype   string
-	method    string
-	fields    []FormField
+	value string
+	dirty bool
 }
 
-func NewForm(m *Mechanize, n *html.Node) *Form {
-	f := &Form{
-		Node:      n,
-		enctype:   contentTypeFormUrlEncoded,
-		mechanize: m,
+func NewButton(n *html.Node) *Button {
+	return &Button{
+		Node: n,
 	}
-	f.parse()
-	return f
 }
 
-func (f *Form) parse() {
-	for _, attr := range f.Attr {
+func (b Button) RawNode() *html.Node {
+	return b.Node
+}
+
+func (b Button) Name() string {
+	v, _ := getAttr(b.Node, "name")
+	return v
+}
+
+func (b Button) Value() string {
+	if b.dirty {
+		return b.value
+	}
+	return b.DefaultValue()
+}
+
+// DefaultValue returns the value attribute of the button
+func (b Button) DefaultValue() string {
+	v, _ := getAttr(b.Node, "value")
+	return v
+}
+
+func (b *Button) SetValue(v string) error {
+	b.value = v
+	b.dirty = true
+	return nil
+}
+
+// Type returns the type of the button: "submit", "reset" or "button".
+// Missing or invalid types are treated as "submit"
+func (b Button) Type() string {
+	v, _ := getAttr(b.Node, "type")
+	switch v = strings.ToLower(v); v {
+	case "reset", "button":
+		return v
+	}
+	return "submit"
+}
+
+// IsSubmit returns true if the button can be used to submit the form
+func (b Button) IsSubmit() bool {
+	return b.Type() == "submit"
+}
+
+func (b Button) IsDisabled() bool {
+	return isDisabled(b.Node)
+}
+
+// TextArea is a <textarea> element. Unlike <input>, its value is the
+// text content of the element, not an attribute
+type TextArea struct {
+	*html.Node
+	value string
+}
+
+func NewTextArea(n *html.Node) *TextArea {
+	return &TextArea{
+		Node:  n,
+		value: normalizeLF(textContent(n)),
+	}
+}
+
+func (t TextArea) RawNode() *html.Node {
+	return t.Node
+}
+
+func (t TextArea) Name() string {
+	v, _ := getAttr(t.Node, "name")
+	return v
+}
+
+// Value returns the current value. Line breaks are always represented
+// as a single LF
+func (t TextArea) Value() string {
+	return t.value
+}
+
+// DefaultValue returns the value of the textarea as it was sent by the
+// server, i.e. its text content
+func (t TextArea) DefaultValue() string {
+	return normalizeLF(textContent(t.Node))
+}
+
+func (t TextArea) IsDisabled() bool {
+	return isDisabled(t.Node)
+}
+
+func (t TextArea) IsReadOnly() bool {
+	_, ok := getAttr(t.Node, "readonly")
+	return ok
+}
+
+func (t *TextArea) SetValue(v string) error {
+	t.value = normalizeLF(v)
+	return nil
+}
+
+// normalizeLF replaces CRLF and lone CR with LF
+func normalizeLF(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	return strings.Replace(s, "\r", "\n", -1)
+}
+
+// normalizeCRLF replaces lone CR and lone LF with CRLF, as is done to
+// names and values when a form is submitted
+func normalizeCRLF(s string) string {
+	return strings.Replace(normalizeLF(s), "\n", "\r\n", -1)
+}
+
+// Option is a single <option> element in a <select>
+type Option struct {
+	*html.Node
+	disabled bool
+	label    string
+	selected bool
+	value    string
+	// defaultSelected is the selectedness of the option when the
+	// document was parsed
+	defaultSelected bool
+}
+
+func newOption(n *html.Node, disabled bool) *Option {
+	text := strings.Join(strings.Fields(textContent(n)), " ")
+	o := &Option{
+		Node:     n,
+		disabled: disabled,
+		label:    text,
+		value:    text,
+	}
+
+	for _, attr := range n.Attr {
 		switch attr.Key {
-		case "action":
-			f.action = attr.Val
-		case "method":
-			f.method = attr.Val
-		case "enctype":
-			f.enctype = attr.Val
+		case "value":
+			o.value = attr.Val
+		case "label":
+			if attr.Val != "" {
+				o.label = attr.Val
+			}
+		case "selected":
+			o.selected = true
+		case "disabled":
+			o.disabled = true
 		}
 	}
+	return o
+}
 
-	var fn func(*html.Node)
-	fn = func(n *html.Node) {
-		// descend into children, and find out input elements
-		// TODO: handle buttons and such also...
-		if n.Type == html.ElementNode {
-			if n.Data == "input" {
-				f.fields = append(f.fields, NewInput(n))
+// Value returns the value that is submitted when this option is selected.
+// If the element has no value attribute, this is the text of the option
+func (o *Option) Value() string {
+	return o.value
+}
+
+// Label returns the visible label of the option
+func (o *Option) Label() string {
+	return o.label
+}
+
+func (o *Option) IsSelected() bool {
+	return o.selected
+}
+
+// IsDefaultSelected returns true if the option was selected when the
+// document was parsed
+func (o *Option) IsDefaultSelected() bool {
+	return o.defaultSelected
+}
+
+func (o *Option) IsDisabled() bool {
+	return o.disabled
+}
+
+// Select is a <select> element. Values are chosen amongst the options,
+// and selecting an option that does not exist results in an error
+type Select struct {
+	*html.Node
+	multiple bool
+	options  []*Option
+}
+
+func NewSelect(n *html.Node) *Select {
+	s := &Select{
+		Node: n,
+	}
+	_, s.multiple = getAttr(n, "multiple")
+
+	var fn func(*html.Node, bool)
+	fn = func(n *html.Node, disabled bool) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "option":
+				s.options = append(s.options, newOption(c, disabled))
+			case "optgroup":
+				_, d := getAttr(c, "disabled")
+				fn(c, disabled || d)
 			}
 		}
+	}
+	fn(n, false)
 
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			fn(c)
+	s.resetSelection()
+	for _, o := range s.options {
+		o.defaultSelected = o.selected
+	}
+	return s
+}
+
+// resetSelection applies the rules for the initial selection: a single
+// select has exactly one selected option (the last one marked as selected,
+// or the first enabled option if none are)
+func (s *Select) resetSelection() {
+	if s.multiple {
+		return
+	}
+
+	var selected *Option
+	for _, o := range s.options {
+		if o.selected {
+			selected = o
+		}
+	}
+
+	if selected == nil {
+		if size, _ := getAttr(s.Node, "size"); size != "" && size != "1" {
+			return
 		}
+		for _, o := range s.options {
+			if !o.disabled {
+				selected = o
+				break
+			}
+		}
+	}
+
+	for _, o := range s.options {
+		o.selected = o == selected
 	}
-	fn(f.Node)
 }
 
-func (f *Form) FindField(name string) (FormField, error) {
-	for _, field := range f.fields {
-		if field.Name() == name {
-			return field, nil
+func (s Select) RawNode() *html.Node {
+	return s.Node
+}
+
+func (s Select) Name() string {
+	v, _ := getAttr(s.Node, "name")
+	return v
+}
+
+func (s Select) IsDisabled() bool {
+	return isDisabled(s.Node)
+}
+
+// IsMultiple returns true if more than one option can be selected
+func (s Select) IsMultiple() bool {
+	return s.multiple
+}
+
+func (s Select) Options() []*Option {
+	return s.options
+}
+
+// Value returns the value of the first selected option
+func (s Select) Value() string {
+	if v := s.Values(); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Values returns the values of all selected options, in document order.
+// Disabled options are never included
+func (s Select) Values() []string {
+	ret := []string{}
+	for _, o := range s.options {
+		if o.selected && !o.disabled {
+			ret = append(ret, o.value)
 		}
 	}
-	return nil, errors.New("field not found")
+	return ret
 }
 
-func (f *Form) SetValue(name, value string) error {
-	field, err := f.FindField(name)
+// SetValue selects the option whose value is v, or if there is no such
+// option, the option whose label is v. Any other selected options are
+// deselected
+func (s *Select) SetValue(v string) error {
+	if o, err := s.findOption(v, true); err == nil {
+		return s.selectOptions([]*Option{o})
+	}
+
+	o, err := s.findOption(v, false)
 	if err != nil {
 		return err
 	}
+	return s.selectOptions([]*Option{o})
+}
 
-	field.SetValue(value)
-	return nil
+// SelectByValue selects the options with the given values, deselecting
+// everything else. More than one value may only be specified for
+// a multiple select
+func (s *Select) SelectByValue(values ...string) error {
+	return s.selectBy(values, true)
 }
 
-func (f *Form) FormValues() (url.Values, error) {
-	if f.enctype != contentTypeFormUrlEncoded {
-		return nil, errors.New("form is not an 'application/x-www-form-urlencoded' enctype")
-	}
+// SelectByLabel selects the options with the given labels, deselecting
+// everything else. More than one label may only be specified for
+// a multiple select
+func (s *Select) SelectByLabel(labels ...string) error {
+	return s.selectBy(labels, false)
+}
 
-	values := url.Values{}
-	for _, n := range f.fields {
-		if n.Name() == "" {
-			continue
+func (s *Select) selectBy(keys []string, byValue bool) error {
+	options := make([]*Option, 0, len(keys))
+	for _, k := range keys {
+		o, err := s.findOption(k, byValue)
+		if err != nil {
+			return err
 		}
-		values.Set(n.Name(), n.Value())
+		options = append(options, o)
 	}
-	return values, nil
+	return s.selectOptions(options)
 }
 
-func (f *Form) Submit() error {
-	switch f.enctype {
-	case contentTypeFormUrlEncoded:
-		v, _ := f.FormValues()
-		if err := f.mechanize.PostForm(f.action, v); err != nil {
-			return err
+func (s *Select) findOption(key string, byValue bool) (*Option, error) {
+	for _, o := range s.options {
+		if (byValue && o.value == key) || (!byValue && o.label == key) {
+			if o.disabled {
+				return nil, errors.New("option '" + key + "' is disabled")
+			}
+			return o, nil
 		}
+	}
+	return nil, errors.New("option '" + key + "' not found")
+}
 
-		if res := f.mechanize.LastResponse(); !res.IsSuccess() && !res.IsRedirect() {
-			return errors.New("form submission failed")
-		}
-		return nil
-	case contentTypeMultipartFormData:
-		panic("unimplemented")
-	default:
-		panic("unimplemented")
+// addSelection selects the option with value v in a multiple select,
+// keeping other options selected
+func (s *Select) addSelection(v string) error {
+	if !s.multiple {
+		return errors.New("cannot select multiple options in a single select")
+	}
+
+	o, err := s.findOption(v, true)
+	if err != nil {
+		return err
+	}
+	o.selected = true
+	return nil
+}
+
+func (s *Select) selectOptions(options []*Option) error {
+	if !s.multiple && len(options) > 1 {
+		return errors.New("cannot select multiple options in a single select")
+	}
+
+	for _, o := range s.options {
+		o.selected = false
 	}
-}
\ No newline at end of file
+	for _, o := range options {
+		o.selected = true
+	}
+	return nil
+}
+
+type Form struct {
+	*html.Node
+	mechanize  *Mechanize
+	action     string
+	enctype    string
+	method     string
+	novalidate bool
+	fields     []FormField
+	response   *Response
+}
+
+func NewForm(m *Mechanize, n *html.Node) *Form {
+	f := newForm(m, n)
+
+	// Controls may be associated with the form via the form attribute
+	// from anywhere in the document, so walk the entire document
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	assignFields(root, map[*html.Node]*Form{n: f})
+	return f
+}
+
+// newForm creates a form without any fields. The fields are added by
+// assignFields
+func newForm(m *Mechanize, n *html.Node) *Form {
+	f := &Form{
+		Node:      n,
+		enctype:   contentTypeFormUrlEncoded,
+		mechanize: m,
+	}
+	f.parse()
+	return f
+}
+
+func (f *Form) Name() string {
+	v, _ := getAttr(f.Node, "name")
+	return v
+}
+
+func (f *Form) ID() string {
+	v, _ := getAttr(f.Node, "id")
+	return v
+}
+
+// Action returns the URL that the form is submitted to, when it is
+// submitted without a submitter
+func (f *Form) Action() (*url.URL, error) {
+	return f.resolveAction(f.action)
+}
+
+// Fields returns the fields of the form, in document order
+func (f *Form) Fields() []FormField {
+	return f.fields
+}
+
+func (f *Form) parse() {
+	for _, attr := range f.Attr {
+		switch attr.Key {
+		case "action":
+			f.action = attr.Val
+		case "method":
+			f.method = attr.Val
+		case "enctype":
+			f.enctype = attr.Val
+		case "novalidate":
+			f.novalidate = true
+		}
+	}
+}
+
+// assignFields walks the document once, and adds each control to the
+// fields of its form owner, if the owner is one of forms
+func assignFields(root *html.Node, forms map[*html.Node]*Form) {
+	ids := collectIDs(root)
+
+	var fn func(*html.Node)
+	fn = func(n *html.Node) {
+		// descend into children, and find out input elements
+		if n.Type == html.ElementNode {
+			var field FormField
+			switch n.Data {
+			case "input":
+				field = NewInput(n)
+			case "button":
+				field = NewButton(n)
+			case "select":
+				field = NewSelect(n)
+			case "textarea":
+				field = NewTextArea(n)
+			}
+
+			if field != nil {
+				if f := forms[formOwner(n, ids)]; f != nil {
+					f.fields = append(f.fields, field)
+				}
+				// none of the controls may contain other controls
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			fn(c)
+		}
+	}
+	fn(root)
+
+	for _, f := range forms {
+		f.groupRadios()
+		for _, field := range f.fields {
+			if i, ok := field.(*Input); ok {
+				i.defaultChecked = i.checked
+			}
+		}
+	}
+}
+
+// collectIDs returns a map of id to the first element with that id
+func collectIDs(root *html.Node) map[string]*html.Node {
+	ids := map[string]*html.Node{}
+	var fn func(*html.Node)
+	fn = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if id, ok := getAttr(n, "id"); ok && id != "" {
+				if _, seen := ids[id]; !seen {
+					ids[id] = n
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			fn(c)
+		}
+	}
+	fn(root)
+	return ids
+}
+
+// formOwner returns the form element that owns the control n, or nil.
+// A control with a form attribute belongs to the form with that id (and
+// no other form, even if it is nested in one). Otherwise it belongs to
+// the nearest ancestor form
+func formOwner(n *html.Node, ids map[string]*html.Node) *html.Node {
+	if id, ok := getAttr(n, "form"); ok {
+		if o := ids[id]; o != nil && o.Data == "form" {
+			return o
+		}
+		return nil
+	}
+
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == "form" {
+			return p
+		}
+	}
+	return nil
+}
+
+// groupRadios links radio buttons that share the same name, so that
+// checking one unchecks the others. If more than one radio button in
+// a group is initially checked, the last one wins
+func (f *Form) groupRadios() {
+	groups := map[string][]*Input{}
+	for _, field := range f.fields {
+		i, ok := field.(*Input)
+		if !ok || i.Type() != "radio" || i.Name() == "" {
+			continue
+		}
+		groups[i.Name()] = append(groups[i.Name()], i)
+	}
+
+	for _, group := range groups {
+		var checked *Input
+		for _, i := range group {
+			i.group = group
+			if i.checked {
+				checked = i
+			}
+		}
+		if checked != nil {
+			checked.Check()
+		}
+	}
+}
+
+// checkables returns the checkboxes and radio buttons named name
+func (f *Form) checkables(name string) []*Input {
+	var ret []*Input
+	for _, field := range f.fields {
+		if i, ok := field.(*Input); ok && i.IsCheckable() && i.Name() == name {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+func (f *Form) findCheckable(name, value string) (*Input, error) {
+	inputs := f.checkables(name)
+	if len(inputs) == 0 {
+		return nil, errors.New("field not found")
+	}
+
+	for _, i := range inputs {
+		if i.Value() == value {
+			return i, nil
+		}
+	}
+	return nil, errors.New("no checkbox or radio button '" + name + "' with value '" + value + "'")
+}
+
+// Check checks the checkbox or radio button named name whose value
+// is value
+func (f *Form) Check(name, value string) error {
+	i, err := f.findCheckable(name, value)
+	if err != nil {
+		return err
+	}
+	if err := f.checkWritable(i, false); err != nil {
+		return err
+	}
+	return i.Check()
+}
+
+// Uncheck unchecks the checkbox or radio button named name whose value
+// is value
+func (f *Form) Uncheck(name, value string) error {
+	i, err := f.findCheckable(name, value)
+	if err != nil {
+		return err
+	}
+	if err := f.checkWritable(i, false); err != nil {
+		return err
+	}
+	return i.Uncheck()
+}
+
+// isStrict returns true if changes to fields that cannot be changed
+// in a browser should be rejected. See Mechanize.StrictForms
+func (f *Form) isStrict() bool {
+	return f.mechanize != nil && f.mechanize.StrictForms
+}
+
+// checkWritable returns an error if in strict mode, and the field is
+// one that cannot be changed by a user in a browser
+func (f *Form) checkWritable(field FormField, force bool) error {
+	if force || !f.isStrict() {
+		return nil
+	}
+
+	if d, ok := field.(interface{ IsDisabled() bool }); ok && d.IsDisabled() {
+		return errors.New("field '" + field.Name() + "' is disabled")
+	}
+	if r, ok := field.(interface{ IsReadOnly() bool }); ok && r.IsReadOnly() {
+		return errors.New("field '" + field.Name() + "' is readonly")
+	}
+	if i, ok := field.(*Input); ok && i.IsHidden() {
+		return errors.New("field '" + field.Name() + "' is hidden")
+	}
+	return nil
+}
+
+func (f *Form) FindField(name string) (FormField, error) {
+	for _, field := range f.fields {
+		if field.Name() == name {
+			return field, nil
+		}
+	}
+	return nil, errors.New("field not found")
+}
+
+// SetValue sets the value of the field named name. For checkboxes and
+// radio buttons, the one whose value is value is checked instead.
+// If Mechanize.StrictForms is enabled, changing a disabled, readonly
+// or hidden field results in an error
+func (f *Form) SetValue(name, value string) error {
+	return f.setValue(name, value, false)
+}
+
+// ForceValue is the same as SetValue, but allows changing disabled,
+// readonly and hidden fields even if Mechanize.StrictForms is enabled
+func (f *Form) ForceValue(name, value string) error {
+	return f.setValue(name, value, true)
+}
+
+func (f *Form) setValue(name, value string, force bool) error {
+	if len(f.checkables(name)) > 0 {
+		i, err := f.findCheckable(name, value)
+		if err != nil {
+			return err
+		}
+		if err := f.checkWritable(i, force); err != nil {
+			return err
+		}
+		return i.Check()
+	}
+
+	field, err := f.FindField(name)
+	if err != nil {
+		return err
+	}
+
+	if err := f.checkWritable(field, force); err != nil {
+		return err
+	}
+	return field.SetValue(value)
+}
+
+// fieldsNamed returns all fields named name, in document order.
+// Buttons are not included
+func (f *Form) fieldsNamed(name string) []FormField {
+	var ret []FormField
+	for _, field := range f.fields {
+		switch b := field.(type) {
+		case *Input:
+			if b.IsButton() {
+				continue
+			}
+		case *Button:
+			continue
+		}
+
+		if field.Name() == name {
+			ret = append(ret, field)
+		}
+	}
+	return ret
+}
+
+// Values returns all values that would be submitted for name, in
+// document order
+func (f *Form) Values(name string) []string {
+	var ret []string
+	for _, e := range f.entries(nil) {
+		if e.Name == name {
+			ret = append(ret, e.Value)
+		}
+	}
+	return ret
+}
+
+// SetValues distributes values over the fields named name, in document
+// order. Checkboxes and radio buttons are checked if their value is
+// in values and unchecked otherwise, multiple selects select all options
+// whose values are in values, and every other field takes the next
+// value. Fields are left untouched once values are exhausted. It is an
+// error if not all values could be assigned
+func (f *Form) SetValues(name string, values ...string) error {
+	fields := f.fieldsNamed(name)
+	if len(fields) == 0 {
+		return errors.New("field not found")
+	}
+
+	remaining := append([]string(nil), values...)
+	take := func(v string) bool {
+		for i, r := range remaining {
+			if r == v {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, field := range fields {
+		if err := f.checkWritable(field, false); err != nil {
+			return err
+		}
+
+		switch x := field.(type) {
+		case *Input:
+			if x.IsCheckable() {
+				if take(x.Value()) {
+					x.Check()
+				} else {
+					x.Uncheck()
+				}
+				continue
+			}
+		case *Select:
+			if x.IsMultiple() {
+				var options []*Option
+				for _, o := range x.options {
+					if !o.disabled && take(o.value) {
+						options = append(options, o)
+					}
+				}
+				x.selectOptions(options)
+				continue
+			}
+		}
+
+		if len(remaining) == 0 {
+			continue
+		}
+		if err := field.SetValue(remaining[0]); err != nil {
+			return err
+		}
+		remaining = remaining[1:]
+	}
+
+	if len(remaining) > 0 {
+		return errors.New("too many values for field '" + name + "'")
+	}
+	return nil
+}
+
+// AddValue adds value to the values submitted for name. An unchecked
+// checkbox or an unselected option in a multiple select with the same
+// value is checked or selected. Otherwise, a new hidden field is added
+// to the form
+func (f *Form) AddValue(name, value string) error {
+	for _, field := range f.fieldsNamed(name) {
+		switch x := field.(type) {
+		case *Input:
+			if x.Type() == "checkbox" && !x.IsChecked() && x.Value() == value {
+				if err := f.checkWritable(x, false); err != nil {
+					return err
+				}
+				return x.Check()
+			}
+		case *Select:
+			if !x.IsMultiple() {
+				continue
+			}
+			if o, err := x.findOption(value, true); err == nil && !o.selected {
+				if err := f.checkWritable(x, false); err != nil {
+					return err
+				}
+				return x.addSelection(value)
+			}
+		}
+	}
+
+	n := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "input",
+		DataAtom: atom.Input,
+		Attr: []html.Attribute{
+			{Key: "type", Val: "hidden"},
+			{Key: "name", Val: name},
+			{Key: "value", Val: value},
+		},
+	}
+	f.Node.AppendChild(n)
+	i := NewInput(n)
+	i.added = true
+	f.fields = append(f.fields, i)
+	return nil
+}
+
+// SetFile sets the files to be uploaded via the file upload field
+// named name
+func (f *Form) SetFile(name string, files ...*UploadFile) error {
+	field, err := f.FindField(name)
+	if err != nil {
+		return err
+	}
+
+	i, ok := field.(*Input)
+	if !ok {
+		return errors.New("field '" + name + "' is not a file upload field")
+	}
+	if err := f.checkWritable(i, false); err != nil {
+		return err
+	}
+	return i.SetFile(files...)
+}
+
+func (f *Form) FormValues() (url.Values, error) {
+	if f.enctype != contentTypeFormUrlEncoded {
+		return nil, errors.New("form is not an 'application/x-www-form-urlencoded' enctype")
+	}
+
+	return f.formValues(nil), nil
+}
+
+// FormEntry is a single name/value pair to be submitted
+type FormEntry struct {
+	Name string
+	// Value is the value of the field. For file upload fields, this
+	// is the file name
+	Value string
+	// IsFile is true if the entry is from a file upload field. File is
+	// nil if no file was selected
+	IsFile bool
+	File   *UploadFile
+}
+
+// Entries returns the list of entries that would be submitted, in
+// document order. Repeated names are preserved
+func (f *Form) Entries() []FormEntry {
+	return f.entries(nil)
+}
+
+// encodeEntries encodes the entries as application/x-www-form-urlencoded,
+// preserving their order (unlike url.Values.Encode, which sorts by name)
+func encodeEntries(entries []FormEntry) string {
+	var buf bytes.Buffer
+	for i, e := range entries {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(url.QueryEscape(e.Name))
+		buf.WriteByte('=')
+		buf.WriteString(url.QueryEscape(e.Value))
+	}
+	return buf.String()
+}
+
+// entries builds the list of entries to be submitted, in document order.
+// Buttons are excluded, except for submitter which is the button that
+// was clicked, if any
+func (f *Form) entries(submitter FormField) []FormEntry {
+	var entries []FormEntry
+	for _, n := range f.fields {
+		name := normalizeCRLF(n.Name())
+		if isDisabled(n.RawNode()) {
+			continue
+		}
+
+		if i, ok := n.(*Input); ok && i.IsImage() {
+			// image buttons send the coordinates of the click instead
+			// of a value, even if they have no name
+			if n != submitter {
+				continue
+			}
+			prefix := ""
+			if name != "" {
+				prefix = name + "."
+			}
+			entries = append(entries,
+				FormEntry{Name: prefix + "x", Value: strconv.Itoa(i.x)},
+				FormEntry{Name: prefix + "y", Value: strconv.Itoa(i.y)},
+			)
+			continue
+		}
+
+		if name == "" {
+			continue
+		}
+
+		switch field := n.(type) {
+		case *Select:
+			for _, v := range field.Values() {
+				entries = append(entries, FormEntry{Name: name, Value: normalizeCRLF(v)})
+			}
+			continue
+		case *Input:
+			if field.IsButton() && n != submitter {
+				continue
+			}
+			if field.IsCheckable() && !field.IsChecked() {
+				continue
+			}
+			if field.IsFile() {
+				if len(field.files) == 0 {
+					entries = append(entries, FormEntry{Name: name, IsFile: true})
+				}
+				for _, file := range field.files {
+					entries = append(entries, FormEntry{Name: name, Value: file.filename(), IsFile: true, File: file})
+				}
+				continue
+			}
+			if field.IsHidden() && name == "_charset_" && !field.dirty && !hasAttr(field.Node, "value") {
+				// a special field that is sent with the name of the
+				// character encoding used to submit the form
+				_, cs := f.charsetEncoding()
+				entries = append(entries, FormEntry{Name: name, Value: cs})
+				continue
+			}
+		case *Button:
+			if n != submitter {
+				continue
+			}
+		}
+		entries = append(entries, FormEntry{Name: name, Value: normalizeCRLF(n.Value())})
+	}
+	return entries
+}
+
+// formValues builds the values to be submitted as
+// application/x-www-form-urlencoded. File upload fields are sent as
+// their file names
+func (f *Form) formValues(submitter FormField) url.Values {
+	values := url.Values{}
+	for _, e := range f.entries(submitter) {
+		values.Add(e.Name, e.Value)
+	}
+	return values
+}
+
+// submitters returns the buttons that can be used to submit the form.
+// Disabled buttons cannot be clicked
+func (f *Form) submitters() []FormField {
+	var ret []FormField
+	for _, field := range f.fields {
+		if isDisabled(field.RawNode()) {
+			continue
+		}
+
+		switch b := field.(type) {
+		case *Input:
+			if b.IsSubmit() {
+				ret = append(ret, b)
+			}
+		case *Button:
+			if b.IsSubmit() {
+				ret = append(ret, b)
+			}
+		}
+	}
+	return ret
+}
+
+// FindSubmitter returns the submit button specified by sel, which is
+// either the name of the button or a query selector. If sel is empty,
+// the first submit button in the form is returned
+func (f *Form) FindSubmitter(sel string) (FormField, error) {
+	submitters := f.submitters()
+	if len(submitters) == 0 {
+		return nil, errors.New("form has no submit buttons")
+	}
+
+	if sel == "" {
+		return submitters[0], nil
+	}
+
+	for _, b := range submitters {
+		if b.Name() == sel {
+			return b, nil
+		}
+	}
+
+	// buttons may be associated with the form via the form attribute
+	// from anywhere in the document, so search the entire document
+	root := f.Node
+	for root.Parent != nil {
+		root = root.Parent
+	}
+
+	ms, err := query.Compile(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := query.MatchNodes(root, ms)
+	for _, b := range submitters {
+		for _, n := range nodes {
+			if b.RawNode() == n {
+				return b, nil
+			}
+		}
+	}
+	return nil, errors.New("submit button not found")
+}
+
+// submission holds the parameters used to submit the form, after
+// applying overrides from the submitter
+type submission struct {
+	action     string
+	enctype    string
+	method     string
+	novalidate bool
+}
+
+func (f *Form) submission(submitter FormField) submission {
+	sub := submission{
+		action:     f.action,
+		enctype:    f.enctype,
+		method:     f.method,
+		novalidate: f.novalidate,
+	}
+
+	if submitter != nil {
+		n := submitter.RawNode()
+		if v, ok := getAttr(n, "formaction"); ok {
+			sub.action = v
+		}
+		if v, ok := getAttr(n, "formenctype"); ok {
+			sub.enctype = v
+		}
+		if v, ok := getAttr(n, "formmethod"); ok {
+			sub.method = v
+		}
+		if _, ok := getAttr(n, "formnovalidate"); ok {
+			sub.novalidate = true
+		}
+	}
+
+	switch sub.enctype = strings.ToLower(sub.enctype); sub.enctype {
+	case contentTypeFormUrlEncoded, contentTypeMultipartFormData:
+	default:
+		sub.enctype = contentTypeFormUrlEncoded
+	}
+
+	// missing and invalid methods default to GET
+	switch sub.method = strings.ToLower(sub.method); sub.method {
+	case "post", "dialog":
+	default:
+		sub.method = "get"
+	}
+	return sub
+}
+
+// resolveAction resolves the action against the URL of the page that
+// the form was found in, which is not necessarily the current page.
+// An empty action refers to the page itself
+func (f *Form) resolveAction(action string) (*url.URL, error) {
+	action = strings.TrimSpace(action)
+	if f.response == nil || f.response.Request == nil {
+		return url.Parse(action)
+	}
+
+	if action == "" {
+		u := *f.response.Request.URL
+		return &u, nil
+	}
+
+	u, err := url.Parse(action)
+	if err != nil {
+		return nil, err
+	}
+	return f.response.ResolveURL(u), nil
+}
+
+// Submit submits the form without a submitter, so no buttons are
+// included in the submitted values. Unless the form has the novalidate
+// attribute, the form is validated first (see Validate), and a
+// *ValidationError is returned if there are any violations. The request
+// is sent according to the method of the form: GET replaces the query
+// string of the action URL with the form values, POST sends them as the
+// request body, and "dialog" does not send anything at all
+func (f *Form) Submit() error {
+	return f.submit(nil)
+}
+
+// Click submits the form as if the submit button specified by sel
+// was clicked. See FindSubmitter for the meaning of sel. The name and
+// value of the button is included in the submitted values, and its
+// formaction, formmethod, formenctype and formnovalidate attributes
+// override those of the form. Image buttons are clicked at (0, 0)
+func (f *Form) Click(sel string) error {
+	return f.ClickAt(sel, 0, 0)
+}
+
+// ClickAt is the same as Click, but clicks at the coordinates (x, y)
+// relative to the top left corner of the button. Image buttons submit
+// the coordinates as name.x and name.y (or x and y if the button has
+// no name) instead of a value. The coordinates are ignored for other
+// buttons
+func (f *Form) ClickAt(sel string, x, y int) error {
+	b, err := f.FindSubmitter(sel)
+	if err != nil {
+		return err
+	}
+
+	if i, ok := b.(*Input); ok && i.IsImage() {
+		i.x, i.y = x, y
+	}
+	return f.submit(b)
+}
+
+func (f *Form) submit(submitter FormField) error {
+	sub := f.submission(submitter)
+	if !sub.novalidate {
+		if violations := f.Validate(); len(violations) > 0 {
+			return &ValidationError{Violations: violations}
+		}
+	}
+
+	if sub.method == "dialog" {
+		// submitting a form in a dialog just closes the dialog
+		return nil
+	}
+
+	u, err := f.resolveAction(sub.action)
+	if err != nil {
+		return err
+	}
+
+	entries, err := f.encodedEntries(submitter)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sub.method == "get":
+		// GET always uses application/x-www-form-urlencoded, and
+		// replaces the query string of the action
+		u.RawQuery = encodeEntries(entries)
+		err = f.mechanize.Get(u.String())
+	case sub.enctype == contentTypeMultipartFormData:
+		err = f.postMultipart(u.String(), entries)
+	default:
+		err = f.mechanize.Post(u.String(), contentTypeFormUrlEncoded, strings.NewReader(encodeEntries(entries)))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if res := f.mechanize.LastResponse(); !res.IsSuccess() && !res.IsRedirect() {
+		return errors.New("form submission failed")
+	}
+	return nil
+}
+
+// postMultipart streams the entries as multipart/form-data, so that
+// the content of the files are not read into memory. Unless a file is
+// read from an io.Reader, the body is rebuilt from the entries when the
+// request is replayed (e.g. by Reload, or a 307 redirect)
+func (f *Form) postMultipart(action string, entries []FormEntry) error {
+	// every copy of the body must use the same boundary, as the
+	// Content-Type header is only set once
+	mw := multipart.NewWriter(ioutil.Discard)
+	open := func() io.ReadCloser {
+		pr, pw := io.Pipe()
+		w := multipart.NewWriter(pw)
+		w.SetBoundary(mw.Boundary())
+		go func() {
+			pw.CloseWithError(writeMultipart(w, entries))
+		}()
+		return pr
+	}
+
+	body := open()
+	defer body.Close()
+
+	req, err := f.mechanize.BuildRequest("POST", action, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	if isReplayable(entries) {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return open(), nil
+		}
+	}
+
+	return f.mechanize.SendRequest(req)
+}
@@ -14,14 +14,30 @@ const (
 	ItemClassName
 	ItemIDPrefixPound
 	ItemID
+	ItemAttrStart
+	ItemAttrName
+	ItemAttrOperator
+	ItemAttrValue
+	ItemAttrFlag
+	ItemAttrEnd
+	ItemCombinator
+	ItemPseudoClassPrefixColon
+	ItemPseudoClass
+	ItemPseudoClassArgument
+	ItemComma
 )
 
 func makeLexer(q string) lex.Lexer {
 	return lex.NewStringLexer(q, lexStart)
 }
 
+func skipWhitespace(l lex.Lexer) {
+	l.AcceptRun(" \t\r\n\f")
+	l.Ignore()
+}
+
 func lexStart(l lex.Lexer) lex.LexFn {
-	l.AcceptRun(" \t")
+	skipWhitespace(l)
 
 	// must start with an element specification
 	switch r := l.Peek(); {
@@ -29,10 +45,27 @@ func lexStart(l lex.Lexer) lex.LexFn {
 		l.Emit(lex.ItemEOF)
 		return nil
 	case r == '#':
+		l.Emit(ItemMatchAnyElementShortHand)
 		return lexIDSelector
 	case r == '.':
 		l.Emit(ItemMatchAnyElementShortHand)
 		return lexClassName
+	case r == '[':
+		l.Emit(ItemMatchAnyElementShortHand)
+		return lexAttrSelector
+	case r == ':':
+		l.Emit(ItemMatchAnyElementShortHand)
+		return lexPseudoClass
+	case r == '>' || r == '+' || r == '~':
+		// the descendant combinator is implied by whitespace, and
+		// is not emitted
+		l.Next()
+		l.Emit(ItemCombinator)
+		return lexStart
+	case r == ',':
+		l.Next()
+		l.Emit(ItemComma)
+		return lexStart
 	case r == '*':
 		l.Next()
 		l.Emit(ItemMatchAnyElement)
@@ -81,12 +114,21 @@ func lexIDSelector(l lex.Lexer) lex.LexFn {
 
 	l.Emit(ItemID)
 
-	return lexStart
+	return lexElementSpecSuffix
 }
 
+// lexElementSpecSuffix lexes the class names, ids and attribute
+// selectors that follow an element specification
 func lexElementSpecSuffix(l lex.Lexer) lex.LexFn {
-	if l.Peek() == '.' {
+	switch l.Peek() {
+	case '.':
 		return lexClassName
+	case '#':
+		return lexIDSelector
+	case '[':
+		return lexAttrSelector
+	case ':':
+		return lexPseudoClass
 	}
 
 	return lexStart
@@ -118,7 +160,118 @@ func lexClassName(l lex.Lexer) lex.LexFn {
 
 	l.Emit(ItemClassName)
 
-	return lexStart
+	return lexElementSpecSuffix
+}
+
+// lexAttrSelector lexes [name], [name=value], [name="value"] and
+// [name=value i], where = may also be one of ~=, |=, ^=, $= and *=
+func lexAttrSelector(l lex.Lexer) lex.LexFn {
+	if l.Next() != '[' {
+		l.EmitErrorf("expected attribute selector")
+		return nil
+	}
+	l.Emit(ItemAttrStart)
+	skipWhitespace(l)
+
+	if !acceptAttrName(l) {
+		l.EmitErrorf("expected attribute name")
+		return nil
+	}
+	l.Emit(ItemAttrName)
+	skipWhitespace(l)
+
+	if l.Peek() == ']' {
+		return lexAttrEnd
+	}
+	return lexAttrOperator
+}
+
+func acceptAttrName(l lex.Lexer) bool {
+	n := 0
+	for {
+		r := l.Next()
+		if r == '-' || r == '_' || unicode.IsLetter(r) || (n > 0 && (r == ':' || unicode.IsDigit(r))) {
+			n++
+			continue
+		}
+		l.Backup()
+		return n > 0
+	}
+}
+
+func lexAttrOperator(l lex.Lexer) lex.LexFn {
+	l.AcceptAny("~|^$*")
+	if !l.AcceptAny("=") {
+		l.EmitErrorf("expected ']' or attribute operator")
+		return nil
+	}
+	l.Emit(ItemAttrOperator)
+	skipWhitespace(l)
+
+	return lexAttrValue
+}
+
+func lexAttrValue(l lex.Lexer) lex.LexFn {
+	switch r := l.Peek(); r {
+	case '"', '\'':
+		if !acceptQuoted(l, r) {
+			l.EmitErrorf("unterminated string in attribute selector")
+			return nil
+		}
+	default:
+		if !acceptUnquotedValue(l) {
+			l.EmitErrorf("expected attribute value")
+			return nil
+		}
+	}
+	l.Emit(ItemAttrValue)
+	skipWhitespace(l)
+
+	if l.AcceptAny("iIsS") {
+		l.Emit(ItemAttrFlag)
+		skipWhitespace(l)
+	}
+	return lexAttrEnd
+}
+
+// acceptQuoted accepts a string quoted with q. Backslash escapes are
+// kept as is, and are interpreted by the matcher
+func acceptQuoted(l lex.Lexer, q rune) bool {
+	l.Next()
+	for {
+		switch l.Next() {
+		case q:
+			return true
+		case '\\':
+			if l.Next() == lex.EOF {
+				return false
+			}
+		case lex.EOF, '\n':
+			return false
+		}
+	}
+}
+
+func acceptUnquotedValue(l lex.Lexer) bool {
+	n := 0
+	for {
+		switch r := l.Next(); r {
+		case lex.EOF, ']', ' ', '\t', '\r', '\n', '\f', '"', '\'':
+			l.Backup()
+			return n > 0
+		}
+		n++
+	}
+}
+
+func lexAttrEnd(l lex.Lexer) lex.LexFn {
+	if l.Next() != ']' {
+		l.EmitErrorf("expected ']'")
+		return nil
+	}
+	l.Emit(ItemAttrEnd)
+
+	return lexElementSpecSuffix
 }
 
 func acceptIdentPrefix(l lex.Lexer) bool {
@@ -204,12 +357,12 @@ func acceptIdent(l lex.Lexer) bool {
 		return false
 	}
 
-	if !l.AcceptRun("_abcdefghijklmnopqrstuvwxyz") && !acceptNonASCII(l) && !acceptEscape(l) {
+	if !l.AcceptRun("_abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") && !acceptNonASCII(l) && !acceptEscape(l) {
 		return false
 	}
 
 	for {
-		if !l.AcceptRun("-_abcdefghijklmnopqrstuvwxyz") &&
+		if !l.AcceptRun("-_0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") &&
 			!acceptNonASCII(l) &&
 			!acceptEscape(l) {
 			break
@@ -218,3 +371,54 @@ func acceptIdent(l lex.Lexer) bool {
 
 	return true
 }
+
+// lexPseudoClass lexes :name and :name(argument). The argument is
+// emitted without the parentheses, and is interpreted by the parser.
+// Arguments may contain nested parentheses and quoted strings, as in
+// :not(:nth-child(2)) or :has([title=")"])
+func lexPseudoClass(l lex.Lexer) lex.LexFn {
+	if l.Next() != ':' {
+		l.EmitErrorf("expected pseudo-class")
+		return nil
+	}
+	l.Emit(ItemPseudoClassPrefixColon)
+
+	if !acceptIdent(l) {
+		l.EmitErrorf("expected pseudo-class name (ident)")
+		return nil
+	}
+	l.Emit(ItemPseudoClass)
+
+	if l.Peek() != '(' {
+		return lexElementSpecSuffix
+	}
+	l.Next()
+	l.Ignore()
+
+	depth := 0
+	for {
+		switch r := l.Next(); r {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			l.Backup()
+			l.Emit(ItemPseudoClassArgument)
+			l.Next()
+			l.Ignore()
+			return lexElementSpecSuffix
+		case '"', '\'':
+			l.Backup()
+			if !acceptQuoted(l, r) {
+				l.EmitErrorf("unterminated string in pseudo-class argument")
+				return nil
+			}
+		case lex.EOF:
+			l.EmitErrorf("expected ')'")
+			return nil
+		}
+	}
+}
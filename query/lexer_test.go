@@ -14,6 +14,22 @@ func TestLexer(t *testing.T) {
 		"hello world":              {ItemElementName, ItemElementName},
 		"hello.world":              {ItemElementName, ItemClassNamePrefixDot, ItemClassName},
 		"hello.world bomdia.mundo": {ItemElementName, ItemClassNamePrefixDot, ItemClassName, ItemElementName, ItemClassNamePrefixDot, ItemClassName},
+		"[name]":                   {ItemMatchAnyElementShortHand, ItemAttrStart, ItemAttrName, ItemAttrEnd},
+		"a[href^='https']":         {ItemElementName, ItemAttrStart, ItemAttrName, ItemAttrOperator, ItemAttrValue, ItemAttrEnd},
+		"a[ rel ~= next i ]":       {ItemElementName, ItemAttrStart, ItemAttrName, ItemAttrOperator, ItemAttrValue, ItemAttrFlag, ItemAttrEnd},
+		"form.login[action]":       {ItemElementName, ItemClassNamePrefixDot, ItemClassName, ItemAttrStart, ItemAttrName, ItemAttrEnd},
+		"[a=\"x]y\"]":              {ItemMatchAnyElementShortHand, ItemAttrStart, ItemAttrName, ItemAttrOperator, ItemAttrValue, ItemAttrEnd},
+		"[a=\"x":                   {ItemMatchAnyElementShortHand, ItemAttrStart, ItemAttrName, ItemAttrOperator, lex.ItemError},
+		"[a!=x]":                   {ItemMatchAnyElementShortHand, ItemAttrStart, ItemAttrName, lex.ItemError},
+		"ul > li":                  {ItemElementName, ItemCombinator, ItemElementName},
+		"h1+p~p":                   {ItemElementName, ItemCombinator, ItemElementName, ItemCombinator, ItemElementName},
+		"li:first-child":           {ItemElementName, ItemPseudoClassPrefixColon, ItemPseudoClass},
+		"td:nth-child(2n + 1).x":   {ItemElementName, ItemPseudoClassPrefixColon, ItemPseudoClass, ItemPseudoClassArgument, ItemClassNamePrefixDot, ItemClassName},
+		":root":                    {ItemMatchAnyElementShortHand, ItemPseudoClassPrefixColon, ItemPseudoClass},
+		"td:nth-child(2":           {ItemElementName, ItemPseudoClassPrefixColon, ItemPseudoClass, lex.ItemError},
+		"p:not(:nth-child(2), a)":  {ItemElementName, ItemPseudoClassPrefixColon, ItemPseudoClass, ItemPseudoClassArgument},
+		"p:has([a=')'])":           {ItemElementName, ItemPseudoClassPrefixColon, ItemPseudoClass, ItemPseudoClassArgument},
+		"a, b":                     {ItemElementName, ItemComma, ItemElementName},
 	}
 
 	for input, expected := range tests {
@@ -32,4 +48,4 @@ func TestLexer(t *testing.T) {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
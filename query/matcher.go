@@ -1,42 +1,98 @@
 package query
 
 import (
+	"bytes"
+	"strconv"
 	"strings"
 
 	"golang.org/x/net/html"
 )
 
+// matcher matches a compound selector, such as div.header[title]. In
+// a compiled query, combinator is the relation to the previous matcher:
+// ' ' (descendant), '>' (child), '+' (next sibling) or '~' (subsequent
+// sibling)
 type matcher struct {
+	combinator  byte
 	id          string
 	elementName string
-	className   string
+	classNames  []string
+	attrs       []attrMatcher
+	pseudos     []pseudoMatcher
 }
 
-func CompileQuery(q string) []matcher {
-	l := makeLexer(q)
-	go l.Run()
-
-	matchers := []matcher{}
-	for item := range l.Items() {
-		switch item.Type() {
-		case ItemID:
-			m := matcher{}
-			m.id = item.Value()
-			matchers = append(matchers, m)
-		case ItemMatchAnyElement, ItemMatchAnyElementShortHand:
-			m := matcher{}
-			m.elementName = "*"
-			matchers = append(matchers, m)
-		case ItemElementName:
-			m := matcher{}
-			m.elementName = item.Value()
-			matchers = append(matchers, m)
-		case ItemClassName:
-			matchers[len(matchers)-1].className = item.Value()
-		}
-	}
-
-	return matchers
+// attrMatcher matches an attribute selector such as [name], [name=value]
+// or [name^=value i]
+type attrMatcher struct {
+	name            string
+	op              string
+	value           string
+	caseInsensitive bool
+}
+
+// Compile compiles the query q, which may be a comma separated list of
+// selectors, and returns an error if q cannot be parsed
+func Compile(q string) ([]Selector, error) {
+	ast, err := Parse(q)
+	if err != nil {
+		return nil, err
+	}
+	return ast.Selectors, nil
+}
+
+// CompileQuery is like Compile, but if q cannot be parsed, the result
+// matches nothing
+func CompileQuery(q string) []Selector {
+	sels, _ := Compile(q)
+	return sels
+}
+
+// unquote removes the quotes around a string, if any, and interprets
+// CSS escapes: a backslash followed by up to 6 hex digits is a code
+// point, and a backslash followed by anything else is that character
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var buf bytes.Buffer
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '\\' || i == len(rs)-1 {
+			buf.WriteRune(rs[i])
+			continue
+		}
+		i++
+
+		j := i
+		for j < len(rs) && j-i < 6 && strings.ContainsRune("0123456789abcdefABCDEF", rs[j]) {
+			j++
+		}
+		if j == i {
+			// an escaped newline is a line continuation
+			if rs[i] != '\n' {
+				buf.WriteRune(rs[i])
+			}
+			continue
+		}
+
+		cp, _ := strconv.ParseUint(string(rs[i:j]), 16, 32)
+		if cp == 0 || cp > 0x10FFFF || (cp >= 0xD800 && cp <= 0xDFFF) {
+			cp = 0xFFFD
+		}
+		buf.WriteRune(rune(cp))
+
+		// a single whitespace after a hex escape is consumed
+		if j < len(rs) && strings.ContainsRune(" \t\n\r\f", rs[j]) {
+			j++
+		}
+		i = j - 1
+	}
+	return buf.String()
 }
 
 func (m matcher) Match(n *html.Node) bool {
@@ -44,43 +100,168 @@ func (m matcher) Match(n *html.Node) bool {
 		return false
 	}
 
+	if name := m.elementName; name != "*" {
+		if n.Data != name {
+			return false
+		}
+	}
+
 	if id := m.id; id != "" {
-		found := false
-		for _, attr := range n.Attr {
-			if attr.Key != "id" {
-				continue
-			}
+		if v, ok := getAttr(n, "id"); !ok || v != id {
+			return false
+		}
+	}
 
-			if attr.Val == id {
-				found = true
-				break
+	if len(m.classNames) > 0 {
+		v, _ := getAttr(n, "class")
+		classes := strings.Fields(v)
+		for _, name := range m.classNames {
+			if !containsString(classes, name) {
+				return false
 			}
 		}
-		return found
 	}
 
-	if name := m.elementName; name != "*" {
-		if n.Data != name {
+	for _, a := range m.attrs {
+		if !a.Match(n) {
+			return false
+		}
+	}
+
+	for _, p := range m.pseudos {
+		if !p.Match(n) {
 			return false
 		}
 	}
 
-	if name := m.className; name != "" {
-		found := false
-		for _, attr := range n.Attr {
-			if attr.Key != "class" {
-				continue
+	return true
+}
+
+// matchSelector returns true if n matches the last matcher in ms, and
+// the rest of ms match the elements related to n by the combinators,
+// working from right to left. If scope is not nil, ms is a relative
+// selector, and the first matcher must be related to scope by its
+// combinator
+func matchSelector(n *html.Node, ms []matcher, scope *html.Node) bool {
+	last := len(ms) - 1
+	if !ms[last].Match(n) {
+		return false
+	}
+	if last == 0 {
+		return scope == nil || isRelated(scope, n, ms[0].combinator)
+	}
+
+	rest := ms[:last]
+	switch ms[last].combinator {
+	case '>':
+		p := parentElement(n)
+		return p != nil && matchSelector(p, rest, scope)
+	case '+':
+		s := prevElementSibling(n)
+		return s != nil && matchSelector(s, rest, scope)
+	case '~':
+		for s := prevElementSibling(n); s != nil; s = prevElementSibling(s) {
+			if matchSelector(s, rest, scope) {
+				return true
 			}
+		}
+	default:
+		for p := parentElement(n); p != nil; p = parentElement(p) {
+			if matchSelector(p, rest, scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-			if strings.Contains(attr.Val, name) {
-				found = true
-				break
+// isRelated returns true if n is related to scope by the combinator c
+func isRelated(scope, n *html.Node, c byte) bool {
+	switch c {
+	case '>':
+		return n.Parent == scope
+	case '+':
+		return prevElementSibling(n) == scope
+	case '~':
+		for s := prevElementSibling(n); s != nil; s = prevElementSibling(s) {
+			if s == scope {
+				return true
 			}
 		}
-		if !found {
+	default:
+		for p := n.Parent; p != nil; p = p.Parent {
+			if p == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parentElement(n *html.Node) *html.Node {
+	if p := n.Parent; p != nil && p.Type == html.ElementNode {
+		return p
+	}
+	return nil
+}
+
+func prevElementSibling(n *html.Node) *html.Node {
+	for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+func (a attrMatcher) Match(n *html.Node) bool {
+	v, ok := getAttr(n, a.name)
+	if !ok {
+		return false
+	}
+
+	expected := a.value
+	if a.caseInsensitive {
+		v = strings.ToLower(v)
+		expected = strings.ToLower(expected)
+	}
+
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return v == expected
+	case "~=":
+		if expected == "" || strings.ContainsAny(expected, " \t\r\n\f") {
 			return false
 		}
+		return containsString(strings.Fields(v), expected)
+	case "|=":
+		return v == expected || strings.HasPrefix(v, expected+"-")
+	case "^=":
+		return expected != "" && strings.HasPrefix(v, expected)
+	case "$=":
+		return expected != "" && strings.HasSuffix(v, expected)
+	case "*=":
+		return expected != "" && strings.Contains(v, expected)
 	}
+	return false
+}
 
-	return true
+func getAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
@@ -1,11 +1,181 @@
-// +build ignore
-
 package query
 
+import (
+	"errors"
+	"strings"
+
+	"github.com/lestrrat/go-lex"
+)
+
+// Selector is a complex selector: compound selectors joined by
+// combinators, such as "ul.nav > li a"
+type Selector []matcher
+
+// AST is a parsed selector list, such as "ul > li, p.note"
+type AST struct {
+	Selectors []Selector
+}
+
+type token struct {
+	typ lex.ItemType
+	val string
+}
+
+// Parser parses selectors, using the tokens produced by the lexer
+type Parser struct {
+	tokens []token
+	pos    int
+	// relative allows selectors to start with a combinator, as in the
+	// argument of :has(> p)
+	relative bool
+}
+
 func NewParser() *Parser {
+	return &Parser{}
+}
 
+// Parse parses the selector list s
+func Parse(s string) (*AST, error) {
+	return NewParser().Parse(s)
 }
 
-func Parse(s string) *AST {
+// Parse parses the selector list s
+func (p *Parser) Parse(s string) (*AST, error) {
+	l := makeLexer(s)
+	go l.Run()
+
+	p.tokens = p.tokens[:0]
+	p.pos = 0
+	for item := range l.Items() {
+		p.tokens = append(p.tokens, token{typ: item.Type(), val: item.Value()})
+	}
+
+	return p.parseSelectorList()
+}
 
-}
\ No newline at end of file
+// peek returns the current token. Running out of tokens is treated as
+// the end of the input
+func (p *Parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{typ: lex.ItemEOF}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *Parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *Parser) parseSelectorList() (*AST, error) {
+	ast := &AST{}
+	for {
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		ast.Selectors = append(ast.Selectors, sel)
+
+		switch t := p.next(); t.typ {
+		case ItemComma:
+		case lex.ItemEOF:
+			return ast, nil
+		case lex.ItemError:
+			return nil, errors.New(t.val)
+		default:
+			return nil, errors.New("unexpected '" + t.val + "'")
+		}
+	}
+}
+
+func (p *Parser) parseSelector() (Selector, error) {
+	var sel Selector
+	var combinator byte
+	if p.relative {
+		// relative selectors are descendants of the anchor element,
+		// unless another combinator is given
+		combinator = ' '
+	}
+
+	for {
+		t := p.peek()
+		switch t.typ {
+		case ItemCombinator:
+			if (len(sel) == 0 && !p.relative) || (len(sel) > 0 && combinator != 0) {
+				return nil, errors.New("unexpected combinator '" + t.val + "'")
+			}
+			combinator = t.val[0]
+			p.pos++
+			continue
+		case ItemMatchAnyElement, ItemMatchAnyElementShortHand, ItemElementName:
+			m, err := p.parseCompound()
+			if err != nil {
+				return nil, err
+			}
+			if len(sel) > 0 && combinator == 0 {
+				combinator = ' '
+			}
+			m.combinator = combinator
+			combinator = 0
+			sel = append(sel, m)
+			continue
+		case lex.ItemError:
+			return nil, errors.New(t.val)
+		}
+		break
+	}
+
+	if len(sel) == 0 {
+		return nil, errors.New("expected selector")
+	}
+	if combinator != 0 {
+		return nil, errors.New("expected selector after combinator")
+	}
+	return sel, nil
+}
+
+// parseCompound parses an element specification, followed by any
+// number of ids, class names, attribute selectors and pseudo-classes
+func (p *Parser) parseCompound() (matcher, error) {
+	m := matcher{elementName: "*"}
+	if t := p.next(); t.typ == ItemElementName {
+		// element names are case-insensitive in HTML
+		m.elementName = strings.ToLower(t.val)
+	}
+
+	for {
+		t := p.peek()
+		switch t.typ {
+		case ItemIDPrefixPound, ItemClassNamePrefixDot, ItemAttrStart, ItemAttrEnd, ItemPseudoClassPrefixColon:
+		case ItemID:
+			m.id = t.val
+		case ItemClassName:
+			m.classNames = append(m.classNames, t.val)
+		case ItemAttrName:
+			m.attrs = append(m.attrs, attrMatcher{name: strings.ToLower(t.val)})
+		case ItemAttrOperator:
+			m.attrs[len(m.attrs)-1].op = t.val
+		case ItemAttrValue:
+			m.attrs[len(m.attrs)-1].value = unquote(t.val)
+		case ItemAttrFlag:
+			m.attrs[len(m.attrs)-1].caseInsensitive = strings.EqualFold(t.val, "i")
+		case ItemPseudoClass:
+			pm := pseudoMatcher{name: strings.ToLower(t.val)}
+			p.pos++
+			if t := p.peek(); t.typ == ItemPseudoClassArgument {
+				pm.arg = t.val
+				pm.hasArg = true
+				p.pos++
+			}
+			if err := pm.compile(); err != nil {
+				return m, err
+			}
+			m.pseudos = append(m.pseudos, pm)
+			continue
+		default:
+			return m, nil
+		}
+		p.pos++
+	}
+}
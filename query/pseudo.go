@@ -0,0 +1,230 @@
+package query
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// pseudoMatcher matches a pseudo-class such as :first-child or
+// :nth-child(2n+1). For the :nth-* pseudo-classes, a and b are the
+// coefficients of an+b. For :not, :is, :where and :has, selectors is
+// the parsed argument
+type pseudoMatcher struct {
+	name      string
+	arg       string
+	hasArg    bool
+	a, b      int
+	selectors []Selector
+}
+
+// compile validates the pseudo-class and its argument
+func (p *pseudoMatcher) compile() error {
+	switch p.name {
+	case "first-child", "last-child", "only-child", "first-of-type", "last-of-type", "only-of-type", "empty", "root":
+		if p.hasArg {
+			return errors.New(":" + p.name + " does not take an argument")
+		}
+		return nil
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		if !p.hasArg {
+			return errors.New(":" + p.name + " requires an argument")
+		}
+		a, b, err := parseNth(p.arg)
+		if err != nil {
+			return err
+		}
+		p.a, p.b = a, b
+		return nil
+	case "not", "is", "where", "has":
+		if !p.hasArg {
+			return errors.New(":" + p.name + " requires an argument")
+		}
+		parser := NewParser()
+		parser.relative = p.name == "has"
+		ast, err := parser.Parse(p.arg)
+		if err != nil {
+			return err
+		}
+		p.selectors = ast.Selectors
+		return nil
+	}
+	return errors.New("unsupported pseudo-class :" + p.name)
+}
+
+// parseNth parses the an+b notation, including the keywords odd and even
+func parseNth(s string) (int, int, error) {
+	s = strings.ToLower(strings.Join(strings.Fields(s), ""))
+	switch s {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	case "":
+		return 0, 0, errors.New("empty an+b expression")
+	}
+
+	i := strings.IndexByte(s, 'n')
+	if i < 0 {
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, 0, errors.New("invalid an+b expression '" + s + "'")
+		}
+		return 0, b, nil
+	}
+
+	var a int
+	switch coef := s[:i]; coef {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		v, err := strconv.Atoi(coef)
+		if err != nil {
+			return 0, 0, errors.New("invalid an+b expression '" + s + "'")
+		}
+		a = v
+	}
+
+	var b int
+	if rest := s[i+1:]; rest != "" {
+		// the offset must be signed, i.e. 2n+1 and not 2n1
+		if rest[0] != '+' && rest[0] != '-' {
+			return 0, 0, errors.New("invalid an+b expression '" + s + "'")
+		}
+		v, err := strconv.Atoi(rest)
+		if err != nil || strings.ContainsAny(rest[1:], "+-") {
+			return 0, 0, errors.New("invalid an+b expression '" + s + "'")
+		}
+		b = v
+	}
+	return a, b, nil
+}
+
+// matchNth returns true if there is a non-negative integer n such that
+// a*n+b == i
+func matchNth(a, b, i int) bool {
+	if a == 0 {
+		return i == b
+	}
+	d := i - b
+	return d%a == 0 && d/a >= 0
+}
+
+func (p pseudoMatcher) Match(n *html.Node) bool {
+	switch p.name {
+	case "first-child":
+		return siblingIndex(n, false, false) == 1
+	case "last-child":
+		return siblingIndex(n, true, false) == 1
+	case "only-child":
+		return siblingIndex(n, false, false) == 1 && siblingIndex(n, true, false) == 1
+	case "first-of-type":
+		return siblingIndex(n, false, true) == 1
+	case "last-of-type":
+		return siblingIndex(n, true, true) == 1
+	case "only-of-type":
+		return siblingIndex(n, false, true) == 1 && siblingIndex(n, true, true) == 1
+	case "nth-child":
+		return matchNth(p.a, p.b, siblingIndex(n, false, false))
+	case "nth-last-child":
+		return matchNth(p.a, p.b, siblingIndex(n, true, false))
+	case "nth-of-type":
+		return matchNth(p.a, p.b, siblingIndex(n, false, true))
+	case "nth-last-of-type":
+		return matchNth(p.a, p.b, siblingIndex(n, true, true))
+	case "empty":
+		// comments are allowed, but text (even whitespace) is not
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode || c.Type == html.TextNode {
+				return false
+			}
+		}
+		return true
+	case "root":
+		return n.Parent != nil && n.Parent.Type == html.DocumentNode
+	case "not":
+		return !matchAny(n, p.selectors)
+	case "is", "where":
+		// :is and :where only differ in specificity, which does not
+		// matter here
+		return matchAny(n, p.selectors)
+	case "has":
+		for _, sel := range p.selectors {
+			if hasRelative(n, sel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(n *html.Node, selectors []Selector) bool {
+	for _, sel := range selectors {
+		if matchSelector(n, sel, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRelative returns true if any element matches the relative selector
+// sel, anchored at n. Candidates are the descendants of n, or for the
+// sibling combinators, the following siblings of n and their descendants
+func hasRelative(n *html.Node, sel Selector) bool {
+	var fn func(*html.Node) bool
+	fn = func(c *html.Node) bool {
+		if matchSelector(c, sel, n) {
+			return true
+		}
+		for gc := c.FirstChild; gc != nil; gc = gc.NextSibling {
+			if fn(gc) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch sel[0].combinator {
+	case '+', '~':
+		for s := n.NextSibling; s != nil; s = s.NextSibling {
+			if fn(s) {
+				return true
+			}
+		}
+	default:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if fn(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// siblingIndex returns the 1-based position of n amongst its element
+// siblings, counting from the end if fromEnd is true. If ofType is true,
+// only siblings with the same element name are counted
+func siblingIndex(n *html.Node, fromEnd, ofType bool) int {
+	i := 1
+	next := func(s *html.Node) *html.Node {
+		if fromEnd {
+			return s.NextSibling
+		}
+		return s.PrevSibling
+	}
+
+	for s := next(n); s != nil; s = next(s) {
+		if s.Type != html.ElementNode {
+			continue
+		}
+		if ofType && s.Data != n.Data {
+			continue
+		}
+		i++
+	}
+	return i
+}
@@ -11,7 +11,7 @@ type Selection struct {
 }
 
 type ContextNode interface {
-	find([]matcher) *Selection
+	find([]Selector) *Selection
 }
 
 type Document struct {
@@ -35,22 +35,27 @@ func (d *Document) Find(query string) *Selection {
 	return &Selection{Nodes: MatchNodes(d.root, ms)}
 }
 
-func MatchNodes(n *html.Node, ms []matcher) []*html.Node {
-	if len(ms) == 0 {
+// MatchNodes returns the elements in the tree rooted at n (including n)
+// that match any of the selectors in q, in document order. Elements that
+// match more than one selector are returned once. Only the subjects of
+// the query are returned: "ul li" returns the li elements, but not the
+// ul elements. The other elements in the query may be outside of the tree
+func MatchNodes(n *html.Node, q []Selector) []*html.Node {
+	if len(q) == 0 {
 		return nil
 	}
 
 	ret := []*html.Node{}
-	if ms[0].Match(n) {
-		ms = ms[1:]
-		ret = append(ret, n)
-	}
-
-	if len(ms) > 0 {
+	var fn func(*html.Node)
+	fn = func(n *html.Node) {
+		if matchAny(n, q) {
+			ret = append(ret, n)
+		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			ret = append(ret, MatchNodes(c, ms)...)
+			fn(c)
 		}
 	}
+	fn(n)
 
 	return ret
 }
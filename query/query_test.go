@@ -3,6 +3,8 @@ package query
 import (
 	"os"
 	"testing"
+
+	"golang.org/x/net/html"
 )
 
 func newDoc(f string) *Document {
@@ -65,3 +67,284 @@ func TestQueryID(t *testing.T) {
 
 	t.Logf("%#v", s.Nodes)
 }
+
+func TestQueryAttribute(t *testing.T) {
+	d := newDoc("testdata/peco.html")
+
+	tests := map[string]int{
+		"form[action='/peco/peco/search']":           1,
+		"form[action=\"/peco/peco/search\"][method]": 1,
+		"input[name=utf8]":                           1,
+		"meta[http-equiv]":                           3,
+		"meta[property^=\"og:\"]":                    6,
+		"meta[name$=\":title\"]":                     1,
+		"meta[name|=octolytics]":                     12,
+		"meta[content*=\"peco\"][name^=twitter]":     2,
+		"input[class~=js-navigation-enable]":         1,
+		"input[class~=\"js-navigation\"]":            0,
+		"meta[http-equiv='content-language']":        0,
+		"meta[http-equiv='content-language' i]":      1,
+		"meta[name^='']":                             0,
+		"[id='\\73 tart-of-content']":                1,
+		"form[action=\"/peco":                        0,
+	}
+
+	for q, expected := range tests {
+		s := d.Find(q)
+		if len(s.Nodes) != expected {
+			t.Errorf("%s: expected %d nodes, got %d", q, expected, len(s.Nodes))
+			return
+		}
+	}
+}
+
+func TestQueryCombinators(t *testing.T) {
+	d := newDoc("testdata/peco.html")
+
+	tests := []struct {
+		query    string
+		expected int
+		tag      string
+	}{
+		{"ul.header-nav li", 4, "li"},
+		{"ul.header-nav > li > a", 4, "a"},
+		{"ul.header-nav>li>a[href^=https]", 1, "a"},
+		{"ul.header-nav > a", 0, ""},
+		{"li.header-nav-item + li.header-nav-item", 3, "li"},
+		{"li.header-nav-item ~ li", 3, "li"},
+		{"ul.header-nav > li + li + li + li > a", 1, "a"},
+		{"ul.header-nav >", 0, ""},
+		{"> li", 0, ""},
+		{"ul > > li", 0, ""},
+	}
+
+	for _, test := range tests {
+		s := d.Find(test.query)
+		if len(s.Nodes) != test.expected {
+			t.Errorf("%s: expected %d nodes, got %d", test.query, test.expected, len(s.Nodes))
+			return
+		}
+
+		for _, n := range s.Nodes {
+			if n.Data != test.tag {
+				t.Errorf("%s: expected only %s elements, got %s", test.query, test.tag, n.Data)
+				return
+			}
+		}
+	}
+
+	// nested matches are returned once each, in document order
+	order := map[*html.Node]int{}
+	var fn func(*html.Node)
+	fn = func(n *html.Node) {
+		order[n] = len(order)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			fn(c)
+		}
+	}
+	fn(d.root)
+
+	s := d.Find("div div")
+	if len(s.Nodes) == 0 {
+		t.Errorf("expected nested divs")
+		return
+	}
+	for i := 1; i < len(s.Nodes); i++ {
+		if order[s.Nodes[i-1]] >= order[s.Nodes[i]] {
+			t.Errorf("expected nodes to be unique and in document order")
+			return
+		}
+	}
+}
+
+func TestQueryPseudoClasses(t *testing.T) {
+	d := newDoc("testdata/peco.html")
+
+	tests := []struct {
+		query    string
+		expected int
+		class    string
+	}{
+		{"ul.header-nav li:first-child", 1, ""},
+		{"ul.header-nav li:last-child > a[href='/blog']", 1, ""},
+		{"ul.header-nav > li:nth-child(odd)", 2, ""},
+		{"ul.header-nav > li:nth-child(2n+1) > a[href^=https]", 1, ""},
+		{"ul.header-nav > li:nth-child(-n + 2)", 2, ""},
+		{"ul.header-nav > li:nth-last-child(1) a[href='/blog']", 1, ""},
+		{"ul.header-nav > li:nth-of-type(3)", 1, ""},
+		{"ul.header-nav > li:first-of-type", 1, ""},
+		{"ul.header-nav > li:only-child", 0, ""},
+		{"table.files tr > td:nth-child(3)", 43, "message"},
+		{"table.files tr:nth-child(even)", 22, ""},
+		{"table.files tr:first-child > td:last-child", 1, "content"},
+		{"table.files td:nth-last-child(2)", 44, ""},
+		{"#start-of-content:empty", 1, ""},
+		{"ul.header-nav:empty", 0, ""},
+		{":root", 1, ""},
+		{"body:root", 0, ""},
+		{"li:nth-child(2n1)", 0, ""},
+		{"li:first-child(1)", 0, ""},
+		{"li:nth-child", 0, ""},
+		{"li:bogus", 0, ""},
+	}
+
+	for _, test := range tests {
+		s := d.Find(test.query)
+		if len(s.Nodes) != test.expected {
+			t.Errorf("%s: expected %d nodes, got %d", test.query, test.expected, len(s.Nodes))
+			return
+		}
+
+		if test.class == "" {
+			continue
+		}
+		for _, n := range s.Nodes {
+			if v, _ := getAttr(n, "class"); v != test.class {
+				t.Errorf("%s: expected class %s, got %s", test.query, test.class, v)
+				return
+			}
+		}
+	}
+}
+
+func TestParseNth(t *testing.T) {
+	tests := map[string][2]int{
+		"odd":     {2, 1},
+		"even":    {2, 0},
+		"3":       {0, 3},
+		"-2":      {0, -2},
+		"n":       {1, 0},
+		"-n+3":    {-1, 3},
+		"+n-1":    {1, -1},
+		"2n + 1":  {2, 1},
+		" 10N-3 ": {10, -3},
+	}
+
+	for s, expected := range tests {
+		a, b, err := parseNth(s)
+		if err != nil {
+			t.Errorf("failed to parse '%s': %s", s, err)
+			return
+		}
+		if a != expected[0] || b != expected[1] {
+			t.Errorf("%s: expected %dn%+d, got %dn%+d", s, expected[0], expected[1], a, b)
+			return
+		}
+	}
+
+	for _, s := range []string{"", "2n1", "n+", "abc", "2n+-1", "1.5n"} {
+		if _, _, err := parseNth(s); err == nil {
+			t.Errorf("expected '%s' to fail", s)
+			return
+		}
+	}
+}
+
+func TestQueryLogicalPseudoClasses(t *testing.T) {
+	d := newDoc("testdata/peco.html")
+
+	tests := map[string]int{
+		"form:has(input[name=utf8])":                              1,
+		"ul:has(> li.header-nav-item)":                            1,
+		"ul.header-nav:has(> a)":                                  0,
+		"ul.header-nav:has(li > a[href='/blog'])":                 1,
+		"ul.header-nav li:not(:first-child)":                      3,
+		"ul.header-nav li:not(:first-child, :last-child)":         2,
+		"ul.header-nav > li:not(:nth-child(2))":                   3,
+		"ul.header-nav a:is([href='/blog'], [href='/explore'])":   2,
+		"ul.header-nav a:where([href^=https])":                    1,
+		"li.header-nav-item:has(+ li)":                            3,
+		"li.header-nav-item:has(~ li a[href='/blog'])":            3,
+		"ul.header-nav a:not([data-x=')'])":                       4,
+		"ul.header-nav li:is(:first-child, :last-child):has(> a)": 2,
+		"li:not()":        0,
+		"li:has(":         0,
+		"li:has(>)":       0,
+		"li:is(> a)":      0,
+		"li:not":          0,
+		"ul li:has(a) , ": 0,
+	}
+
+	for q, expected := range tests {
+		s := d.Find(q)
+		if len(s.Nodes) != expected {
+			t.Errorf("%s: expected %d nodes, got %d", q, expected, len(s.Nodes))
+			return
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	ast, err := Parse("ul.nav > li a, p")
+	if err != nil {
+		t.Errorf("failed to parse: %s", err)
+		return
+	}
+
+	if len(ast.Selectors) != 2 {
+		t.Errorf("expected 2 selectors, got %d", len(ast.Selectors))
+		return
+	}
+
+	sel := ast.Selectors[0]
+	if len(sel) != 3 {
+		t.Errorf("expected 3 compound selectors, got %d", len(sel))
+		return
+	}
+
+	for i, c := range []byte{0, '>', ' '} {
+		if sel[i].combinator != c {
+			t.Errorf("expected combinator '%c' at %d, got '%c'", c, i, sel[i].combinator)
+			return
+		}
+	}
+
+	for _, q := range []string{"", "ul >", "> li", "a,", "a:has(b", "a:foo"} {
+		if _, err := Parse(q); err == nil {
+			t.Errorf("expected '%s' to fail", q)
+			return
+		}
+		if _, err := Compile(q); err == nil {
+			t.Errorf("expected Compile('%s') to fail", q)
+			return
+		}
+	}
+}
+
+func TestQueryGroups(t *testing.T) {
+	d := newDoc("testdata/peco.html")
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"ul.header-nav, ul.header-nav > li", []string{"ul", "li", "li", "li", "li"}},
+		{"ul.header-nav > li, ul.header-nav", []string{"ul", "li", "li", "li", "li"}},
+		{"li.header-nav-item, ul.header-nav li", []string{"li", "li", "li", "li"}},
+		{"a[href='/blog'] , a[href='/explore']", []string{"a", "a"}},
+		{"input, select, textarea", []string{"input", "input", "input", "input", "input", "textarea"}},
+		{"input, ", nil},
+		{", input", nil},
+	}
+
+	for _, test := range tests {
+		s := d.Find(test.query)
+		if len(s.Nodes) != len(test.expected) {
+			t.Errorf("%s: expected %d nodes, got %d", test.query, len(test.expected), len(s.Nodes))
+			return
+		}
+
+		for i, n := range s.Nodes {
+			if n.Data != test.expected[i] {
+				t.Errorf("%s: expected %s at %d, got %s", test.query, test.expected[i], i, n.Data)
+				return
+			}
+		}
+	}
+
+	s := d.Find("a[href='/blog'], a[href='/explore']")
+	if v, _ := getAttr(s.Nodes[0], "href"); v != "/explore" {
+		t.Errorf("expected nodes in document order, got %s first", v)
+		return
+	}
+}
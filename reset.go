@@ -0,0 +1,73 @@
+package mechanize
+
+// Reset restores every field of the form to its default state, as sent
+// by the server: values, checkedness and selected options are reset,
+// selected files are cleared, and fields added with AddValue are removed
+func (f *Form) Reset() {
+	fields := f.fields[:0]
+	for _, field := range f.fields {
+		switch x := field.(type) {
+		case *Input:
+			if x.added {
+				if x.Parent != nil {
+					x.Parent.RemoveChild(x.Node)
+				}
+				continue
+			}
+			x.value = ""
+			x.dirty = false
+			x.checked = x.defaultChecked
+			x.files = nil
+			x.x, x.y = 0, 0
+		case *Button:
+			x.value = ""
+			x.dirty = false
+		case *TextArea:
+			x.value = x.DefaultValue()
+		case *Select:
+			for _, o := range x.options {
+				o.selected = o.defaultSelected
+			}
+		}
+		fields = append(fields, field)
+	}
+	f.fields = fields
+}
+
+// Changed returns the fields whose state differs from the default state
+// sent by the server, in document order. Fields added with AddValue are
+// always included. This is useful to find out why a submission differs
+// from the one a browser would make
+func (f *Form) Changed() []FormField {
+	var ret []FormField
+	for _, field := range f.fields {
+		if isChanged(field) {
+			ret = append(ret, field)
+		}
+	}
+	return ret
+}
+
+func isChanged(field FormField) bool {
+	switch x := field.(type) {
+	case *Input:
+		if x.added || len(x.files) > 0 {
+			return true
+		}
+		if x.IsCheckable() && x.checked != x.defaultChecked {
+			return true
+		}
+		return x.dirty && x.value != x.DefaultValue()
+	case *Button:
+		return x.dirty && x.value != x.DefaultValue()
+	case *TextArea:
+		return x.value != x.DefaultValue()
+	case *Select:
+		for _, o := range x.options {
+			if o.selected != o.defaultSelected {
+				return true
+			}
+		}
+	}
+	return false
+}
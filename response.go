@@ -6,16 +6,24 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 
 	"github.com/lestrrat/go-mechanize/query"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
 )
 
 type Response struct {
 	*http.Response
 	base       string
+	charset    string
+	encoding   encoding.Encoding
 	forms      []*Form
 	isHTML     bool
+	links      []*Link
 	mechanize  *Mechanize
 	parsedHTML *html.Node
 	rawBody    []byte
@@ -32,6 +40,19 @@ func NewResponse(m *Mechanize, res *http.Response) *Response {
 	return r
 }
 
+// attach binds the response and its forms to m. Responses restored
+// from a HistoryStore do not know which Mechanize they belong to
+func (r *Response) attach(m *Mechanize) {
+	if r.mechanize == m {
+		return
+	}
+
+	r.mechanize = m
+	for _, f := range r.forms {
+		f.mechanize = m
+	}
+}
+
 func (r *Response) IsSuccess() bool {
 	return r.StatusCode >= 200 && r.StatusCode < 300
 }
@@ -52,12 +73,38 @@ func (r *Response) Base() string {
 	return r.base
 }
 
+// Links returns the list of links found in the page, in document order
+func (r *Response) Links() []*Link {
+	return r.links
+}
+
+// ResolveURL resolves u against the <base> element of the page if
+// available, or the URL of the request otherwise
+func (r *Response) ResolveURL(u *url.URL) *url.URL {
+	if r.Request == nil {
+		return u
+	}
+
+	ref := r.Request.URL
+	// <base> in the content takes precedence
+	if r.IsHTML() && r.base != "" {
+		if parsed, err := url.Parse(r.base); err == nil {
+			ref = ref.ResolveReference(parsed)
+		}
+	}
+
+	return ref.ResolveReference(u)
+}
+
 func (r *Response) Forms() []*Form {
 	return r.forms
 }
 
 func (r *Response) Form(sel string) (*Form, error) {
-	ms := query.CompileQuery(sel)
+	ms, err := query.Compile(sel)
+	if err != nil {
+		return nil, err
+	}
 	for _, f := range r.forms {
 		nodes := query.MatchNodes(f.Node, ms)
 		if len(nodes) > 0 {
@@ -67,6 +114,110 @@ func (r *Response) Form(sel string) (*Form, error) {
 	return nil, errors.New("specified for not found")
 }
 
+// FormCriteria specifies the conditions used to find a form. All
+// non-empty conditions must match
+type FormCriteria struct {
+	// Name matches the name attribute of the form
+	Name string
+	// ID matches the id attribute of the form
+	ID string
+	// Action matches against the resolved, absolute action URL
+	Action *regexp.Regexp
+	// Fields lists names of fields that the form must all contain
+	Fields []string
+	// Selector is a query selector, as used by Response.Form
+	Selector string
+	// N selects the Nth (starting from 1) form that matches all other
+	// conditions. 0 is the same as 1
+	N int
+}
+
+// match returns true if f matches c. sels is c.Selector, compiled
+func (c *FormCriteria) match(f *Form, sels []query.Selector) bool {
+	if c.Name != "" && f.Name() != c.Name {
+		return false
+	}
+
+	if c.ID != "" && f.ID() != c.ID {
+		return false
+	}
+
+	if c.Action != nil {
+		u, err := f.Action()
+		if err != nil || !c.Action.MatchString(u.String()) {
+			return false
+		}
+	}
+
+	for _, name := range c.Fields {
+		if _, err := f.FindField(name); err != nil {
+			return false
+		}
+	}
+
+	if c.Selector != "" && len(query.MatchNodes(f.Node, sels)) == 0 {
+		return false
+	}
+
+	return true
+}
+
+// FindForm returns the form in the page that matches c
+func (r *Response) FindForm(c FormCriteria) (*Form, error) {
+	n := c.N
+	if n <= 0 {
+		n = 1
+	}
+
+	var sels []query.Selector
+	if c.Selector != "" {
+		var err error
+		if sels, err = query.Compile(c.Selector); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range r.forms {
+		if !c.match(f, sels) {
+			continue
+		}
+		if n--; n == 0 {
+			return f, nil
+		}
+	}
+	return nil, errors.New("form not found")
+}
+
+// FormWithFields returns the first form that contains all of the
+// named fields
+func (r *Response) FormWithFields(names ...string) (*Form, error) {
+	return r.FindForm(FormCriteria{Fields: names})
+}
+
+// FormByName returns the first form whose name attribute is name
+func (r *Response) FormByName(name string) (*Form, error) {
+	return r.FindForm(FormCriteria{Name: name})
+}
+
+// FormByID returns the first form whose id attribute is id
+func (r *Response) FormByID(id string) (*Form, error) {
+	return r.FindForm(FormCriteria{ID: id})
+}
+
+// FormByAction returns the first form whose resolved action URL
+// matches rx
+func (r *Response) FormByAction(rx *regexp.Regexp) (*Form, error) {
+	return r.FindForm(FormCriteria{Action: rx})
+}
+
+// FormNumber returns the Nth (starting from 1) form in the page
+func (r *Response) FormNumber(n int) (*Form, error) {
+	if n < 1 || n > len(r.forms) {
+		return nil, errors.New("form not found")
+	}
+	return r.forms[n-1], nil
+}
+
 func (r *Response) RawBody() []byte {
 	return r.rawBody
 }
@@ -80,6 +231,68 @@ func (r *Response) parseHeaders() {
 	}
 }
 
+// detectCharset determines the character encoding of the document from
+// the byte order mark, the Content-Type header, or <meta> elements.
+// Documents that do not declare any encoding are treated as UTF-8
+func (r *Response) detectCharset(body []byte) {
+	enc, name, certain := charset.DetermineEncoding(body, r.Header.Get("Content-Type"))
+	// windows-1252 is also the guess when nothing declares an encoding,
+	// which is only reported as uncertain like encodings found in <meta>
+	if !certain && name == "windows-1252" && !hasMetaCharset(body) {
+		enc = nil
+	}
+	if enc == nil || name == "utf-8" {
+		enc, name = encoding.Nop, "utf-8"
+	}
+	r.encoding = enc
+	r.charset = name
+}
+
+// hasMetaCharset returns true if a <meta> element in the first 1024
+// bytes of the document declares an encoding, either with the charset
+// attribute or with http-equiv="Content-Type"
+func hasMetaCharset(body []byte) bool {
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tok.Data != "meta" {
+				continue
+			}
+
+			var httpEquiv, content string
+			for _, attr := range tok.Attr {
+				switch attr.Key {
+				case "charset":
+					if strings.TrimSpace(attr.Val) != "" {
+						return true
+					}
+				case "http-equiv":
+					httpEquiv = attr.Val
+				case "content":
+					content = attr.Val
+				}
+			}
+			if strings.EqualFold(httpEquiv, "content-type") && strings.Contains(strings.ToLower(content), "charset=") {
+				return true
+			}
+		}
+	}
+}
+
+// Charset returns the name of the character encoding of the document,
+// such as "utf-8" or "shift_jis"
+func (r *Response) Charset() string {
+	return r.charset
+}
+
 func (r *Response) IsHTML() bool {
 	return r.isHTML
 }
@@ -94,20 +307,35 @@ func (r *Response) parseHTML() error {
 	}
 	r.rawBody = body
 
+	// The document is parsed as UTF-8, so decode it if necessary
+	r.detectCharset(body)
+	if r.charset != "utf-8" {
+		if decoded, err := r.encoding.NewDecoder().Bytes(body); err == nil {
+			body = decoded
+		}
+	}
+
 	doc, err := html.Parse(bytes.NewReader(body))
 	defer r.Body.Close()
 	if err != nil {
 		return err
 	}
+	r.parsedHTML = doc
 
+	forms := map[*html.Node]*Form{}
 	var f func(*html.Node)
 	f = func(n *html.Node) {
 		if n.Type == html.ElementNode {
 			switch n.Data {
 			case "form":
-				r.forms = append(r.forms, NewForm(r.mechanize, n))
-				//			case "a":
-				//				r.links = append(r.links, NewLink(r.mechanize, n))
+				f := newForm(r.mechanize, n)
+				f.response = r
+				r.forms = append(r.forms, f)
+				forms[n] = f
+			case "a", "area", "frame", "iframe", "link", "meta":
+				if l := NewLink(r, n); l != nil {
+					r.links = append(r.links, l)
+				}
 			case "base":
 				for _, attr := range n.Attr {
 					if attr.Key == "href" {
@@ -123,5 +351,9 @@ func (r *Response) parseHTML() error {
 	}
 	f(doc)
 
+	// associate the controls with their forms in a single pass, rather
+	// than walking the document for each form
+	assignFields(doc, forms)
+
 	return nil
 }
@@ -0,0 +1,126 @@
+package mechanize
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UploadFile is a file to be uploaded via an <input type="file"> field.
+// The content is read from exactly one of Path, Data or Reader, when
+// the form is submitted
+type UploadFile struct {
+	// Filename is the name of the file sent to the server. If empty,
+	// the base name of Path is used
+	Filename string
+	// ContentType is the content type of the file. If empty, it is
+	// guessed from the extension of the file name
+	ContentType string
+	// Path is the path to a file on the local filesystem
+	Path string
+	// Data is the content of the file
+	Data []byte
+	// Reader is a stream to read the content of the file from. It is
+	// read only once, and is not closed
+	Reader io.Reader
+}
+
+func (u *UploadFile) filename() string {
+	if u.Filename != "" {
+		return u.Filename
+	}
+	if u.Path != "" {
+		return filepath.Base(u.Path)
+	}
+	return ""
+}
+
+func (u *UploadFile) contentType() string {
+	if u.ContentType != "" {
+		return u.ContentType
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(u.filename())); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+func (u *UploadFile) open() (io.ReadCloser, error) {
+	switch {
+	case u.Reader != nil:
+		return ioutil.NopCloser(u.Reader), nil
+	case u.Path != "":
+		return os.Open(u.Path)
+	default:
+		return ioutil.NopCloser(bytes.NewReader(u.Data)), nil
+	}
+}
+
+// isReplayable returns true if the content of all files can be read
+// more than once, i.e. none of them are read from an io.Reader
+func isReplayable(entries []FormEntry) bool {
+	for _, e := range entries {
+		if e.File != nil && e.File.Reader != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// multipart/form-data requires '"', CR and LF in names and file names
+// to be percent encoded
+var multipartNameEscaper = strings.NewReplacer("\"", "%22", "\r", "%0D", "\n", "%0A")
+
+func writeMultipart(w *multipart.Writer, entries []FormEntry) error {
+	for _, e := range entries {
+		disposition := `form-data; name="` + multipartNameEscaper.Replace(e.Name) + `"`
+
+		hdr := textproto.MIMEHeader{}
+		if !e.IsFile {
+			hdr.Set("Content-Disposition", disposition)
+			part, err := w.CreatePart(hdr)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(part, e.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// A file input without a file is sent as an empty part with
+		// an empty file name
+		file := e.File
+		if file == nil {
+			file = &UploadFile{}
+		}
+
+		hdr.Set("Content-Disposition", disposition+`; filename="`+multipartNameEscaper.Replace(e.Value)+`"`)
+		hdr.Set("Content-Type", file.contentType())
+		part, err := w.CreatePart(hdr)
+		if err != nil {
+			return err
+		}
+
+		if e.File == nil {
+			continue
+		}
+
+		src, err := file.open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,339 @@
+package mechanize
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/net/html"
+)
+
+// Violation describes a field that does not satisfy one of its HTML5
+// constraints
+type Violation struct {
+	Field FormField
+	// Constraint is the name of the violated constraint: "required",
+	// "pattern", "minlength", "maxlength", "min", "max", "step" or "type"
+	Constraint string
+	Message    string
+}
+
+func (v Violation) String() string {
+	return v.Field.Name() + ": " + v.Message
+}
+
+// ValidationError is returned by Form.Submit and Form.Click when the form
+// fails client-side validation
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return "form validation failed: " + strings.Join(msgs, ", ")
+}
+
+const dateLayout = "2006-01-02"
+
+var (
+	// from the HTML specification's definition of a valid e-mail address
+	emailRx  = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	numberRx = regexp.MustCompile(`^-?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)(?:[eE][+-]?[0-9]+)?$`)
+)
+
+// Validate checks the fields of the form against their HTML5 constraints
+// (required, pattern, minlength, maxlength, min, max, step, and the
+// formats of the email, url, number and date types), and returns the
+// list of violations. Disabled, readonly and hidden fields and buttons
+// are not validated, as in browsers. Also as in browsers, minlength and
+// maxlength are only checked once the value has been changed from the
+// one sent by the server
+func (f *Form) Validate() []Violation {
+	var violations []Violation
+	checkedGroups := map[string]bool{}
+
+	for _, field := range f.fields {
+		n := field.RawNode()
+		if isDisabled(n) {
+			continue
+		}
+
+		switch x := field.(type) {
+		case *Input:
+			if x.IsButton() || x.IsHidden() || x.IsReadOnly() {
+				continue
+			}
+
+			if x.Type() == "radio" {
+				// required applies to the group as a whole
+				if checkedGroups[x.Name()] {
+					continue
+				}
+				checkedGroups[x.Name()] = true
+				if radioGroupRequired(x) && !radioGroupChecked(x) {
+					violations = append(violations, Violation{Field: x, Constraint: "required", Message: "please select one of these options"})
+				}
+				continue
+			}
+
+			violations = append(violations, validateInput(x)...)
+		case *TextArea:
+			if x.IsReadOnly() {
+				continue
+			}
+			v := x.Value()
+			if hasAttr(n, "required") && v == "" {
+				violations = append(violations, Violation{Field: x, Constraint: "required", Message: "please fill out this field"})
+				continue
+			}
+			if v != x.DefaultValue() {
+				violations = append(violations, validateLength(x, v)...)
+			}
+		case *Select:
+			if !hasAttr(n, "required") {
+				continue
+			}
+			empty := true
+			for _, v := range x.Values() {
+				if v != "" {
+					empty = false
+				}
+			}
+			if empty {
+				violations = append(violations, Violation{Field: x, Constraint: "required", Message: "please select an item in the list"})
+			}
+		}
+	}
+	return violations
+}
+
+func radioGroupRequired(i *Input) bool {
+	if hasAttr(i.Node, "required") {
+		return true
+	}
+	for _, other := range i.group {
+		if hasAttr(other.Node, "required") {
+			return true
+		}
+	}
+	return false
+}
+
+func radioGroupChecked(i *Input) bool {
+	if i.IsChecked() {
+		return true
+	}
+	for _, other := range i.group {
+		if other.IsChecked() {
+			return true
+		}
+	}
+	return false
+}
+
+func validateInput(i *Input) []Violation {
+	typ := i.Type()
+	v := i.Value()
+
+	if hasAttr(i.Node, "required") {
+		missing := false
+		switch typ {
+		case "checkbox":
+			missing = !i.IsChecked()
+		case "file":
+			missing = len(i.files) == 0
+		default:
+			missing = v == ""
+		}
+		if missing {
+			return []Violation{{Field: i, Constraint: "required", Message: "please fill out this field"}}
+		}
+	}
+
+	if v == "" {
+		return nil
+	}
+
+	switch typ {
+	case "text", "search", "url", "tel", "email", "password":
+	case "number":
+		return validateNumber(i, v)
+	case "date":
+		return validateDate(i, v)
+	default:
+		return nil
+	}
+
+	var violations []Violation
+	switch typ {
+	case "email":
+		values := []string{v}
+		if hasAttr(i.Node, "multiple") {
+			values = strings.Split(v, ",")
+		}
+		for _, addr := range values {
+			if !emailRx.MatchString(strings.TrimSpace(addr)) {
+				violations = append(violations, Violation{Field: i, Constraint: "type", Message: "'" + addr + "' is not a valid e-mail address"})
+				break
+			}
+		}
+	case "url":
+		if u, err := url.Parse(v); err != nil || u.Scheme == "" {
+			violations = append(violations, Violation{Field: i, Constraint: "type", Message: "'" + v + "' is not a valid URL"})
+		}
+	}
+
+	if pattern, ok := getAttr(i.Node, "pattern"); ok {
+		// Patterns that do not compile are ignored, as in browsers
+		if rx, err := regexp.Compile("^(?:" + pattern + ")$"); err == nil && !rx.MatchString(v) {
+			violations = append(violations, Violation{Field: i, Constraint: "pattern", Message: "please match the requested format"})
+		}
+	}
+
+	if i.dirty {
+		violations = append(violations, validateLength(i, v)...)
+	}
+	return violations
+}
+
+// validateLength checks minlength and maxlength. Lengths are measured in
+// UTF-16 code units, as in browsers
+func validateLength(field FormField, v string) []Violation {
+	n := field.RawNode()
+	l := len(utf16.Encode([]rune(v)))
+
+	var violations []Violation
+	if min, ok := intAttr(n, "minlength"); ok && l < min {
+		violations = append(violations, Violation{Field: field, Constraint: "minlength", Message: fmt.Sprintf("please use at least %d characters (currently %d)", min, l)})
+	}
+	if max, ok := intAttr(n, "maxlength"); ok && l > max {
+		violations = append(violations, Violation{Field: field, Constraint: "maxlength", Message: fmt.Sprintf("please use at most %d characters (currently %d)", max, l)})
+	}
+	return violations
+}
+
+func intAttr(n *html.Node, key string) (int, bool) {
+	v, ok := getAttr(n, key)
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+func parseNumber(v string) (float64, bool) {
+	if !numberRx.MatchString(v) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+func validateNumber(i *Input, v string) []Violation {
+	value, ok := parseNumber(v)
+	if !ok {
+		return []Violation{{Field: i, Constraint: "type", Message: "'" + v + "' is not a valid number"}}
+	}
+
+	var violations []Violation
+	min, hasMin := numberAttr(i.Node, "min", parseNumber)
+	if hasMin && value < min {
+		violations = append(violations, Violation{Field: i, Constraint: "min", Message: fmt.Sprintf("value must be greater than or equal to %v", min)})
+	}
+	if max, ok := numberAttr(i.Node, "max", parseNumber); ok && value > max {
+		violations = append(violations, Violation{Field: i, Constraint: "max", Message: fmt.Sprintf("value must be less than or equal to %v", max)})
+	}
+
+	step := 1.0
+	if s, ok := getAttr(i.Node, "step"); ok {
+		if strings.EqualFold(strings.TrimSpace(s), "any") {
+			return violations
+		}
+		if parsed, ok := parseNumber(strings.TrimSpace(s)); ok && parsed > 0 {
+			step = parsed
+		}
+	}
+
+	base := stepBase(i, min, hasMin, parseNumber)
+	if !isStepAligned(value-base, step) {
+		violations = append(violations, Violation{Field: i, Constraint: "step", Message: fmt.Sprintf("value must be a multiple of %v from %v", step, base)})
+	}
+	return violations
+}
+
+// stepBase returns the base that the value must be a multiple of the
+// step from: min, or the value attribute if there is no min, or zero
+func stepBase(i *Input, min float64, hasMin bool, parse func(string) (float64, bool)) float64 {
+	if hasMin {
+		return min
+	}
+	if v, ok := numberAttr(i.Node, "value", parse); ok {
+		return v
+	}
+	return 0
+}
+
+func isStepAligned(diff, step float64) bool {
+	q := diff / step
+	return math.Abs(q-math.Round(q)) < 1e-9
+}
+
+func parseDate(v string) (float64, bool) {
+	t, err := time.Parse(dateLayout, v)
+	if err != nil {
+		return 0, false
+	}
+	// dates are compared in days since the epoch
+	return float64(t.Unix() / 86400), true
+}
+
+func validateDate(i *Input, v string) []Violation {
+	value, ok := parseDate(v)
+	if !ok {
+		return []Violation{{Field: i, Constraint: "type", Message: "'" + v + "' is not a valid date"}}
+	}
+
+	var violations []Violation
+	min, hasMin := numberAttr(i.Node, "min", parseDate)
+	if hasMin && value < min {
+		s, _ := getAttr(i.Node, "min")
+		violations = append(violations, Violation{Field: i, Constraint: "min", Message: "value must be " + s + " or later"})
+	}
+	if max, ok := numberAttr(i.Node, "max", parseDate); ok && value > max {
+		s, _ := getAttr(i.Node, "max")
+		violations = append(violations, Violation{Field: i, Constraint: "max", Message: "value must be " + s + " or earlier"})
+	}
+
+	// step is in days, and the default step is 1 day
+	if s, ok := getAttr(i.Node, "step"); ok && !strings.EqualFold(strings.TrimSpace(s), "any") {
+		if step, ok := parseNumber(strings.TrimSpace(s)); ok && step > 0 {
+			base := stepBase(i, min, hasMin, parseDate)
+			if !isStepAligned(value-base, step) {
+				violations = append(violations, Violation{Field: i, Constraint: "step", Message: fmt.Sprintf("value must be a multiple of %v days", step)})
+			}
+		}
+	}
+	return violations
+}
+
+func numberAttr(n *html.Node, key string, parse func(string) (float64, bool)) (float64, bool) {
+	v, ok := getAttr(n, key)
+	if !ok {
+		return 0, false
+	}
+	return parse(strings.TrimSpace(v))
+}